@@ -26,6 +26,7 @@ import (
 var (
 	ErrNotDir   = errors.New("file exists but not a directory")
 	ErrDirEmpty = errors.New("directory is empty")
+	ErrDirFull  = errors.New("directory quota exceeded")
 )
 
 type Directory interface {
@@ -27,6 +27,7 @@ import (
 	"os"
 	"sort"
 	"strings"
+	"sync"
 
 	"github.com/CodapeWild/devkit/comerr"
 	"github.com/CodapeWild/devkit/id"
@@ -36,9 +37,25 @@ import (
 var _ Directory = (*SequentialDirectory)(nil)
 
 type SequentialDirectory struct {
-	path  string // directory path
-	idflk *id.IDFlaker
-	stque *set.SingleThreadQueue
+	sync.Mutex
+	path     string // directory path
+	idflk    *id.IDFlaker
+	stque    *set.SingleThreadQueue
+	maxBytes int64 // max total bytes of files, no limit if 0
+	maxFiles int   // max number of files, no limit if 0
+	size     int64 // total bytes of files on disk
+	count    int   // number of files on disk
+}
+
+type SeqDirOption func(seqdir *SequentialDirectory)
+
+// SeqDirWithQuota limits the total bytes and the number of files saved in directory,
+// Save returns ErrDirFull once either limit is hit, zero means no limit.
+func SeqDirWithQuota(maxBytes int64, maxFiles int) SeqDirOption {
+	return func(seqdir *SequentialDirectory) {
+		seqdir.maxBytes = maxBytes
+		seqdir.maxFiles = maxFiles
+	}
 }
 
 func (seqdir *SequentialDirectory) List() ([]fs.DirEntry, error) {
@@ -58,38 +75,83 @@ func (seqdir *SequentialDirectory) Open(_ string) (fs.File, error) {
 	return os.Open(seqdir.formatPath(id.String('-')))
 }
 
+// OpenAndDelete takes the earliest file off the sequence, reads and deletes it, the file is
+// popped first so concurrent callers never read or delete the same file.
 func (seqdir *SequentialDirectory) OpenAndDelete(_ string) (string, *bytes.Buffer, error) {
-	f, err := seqdir.Open("")
+	value, _ := seqdir.stque.Pop()
+	if value == nil {
+		return "", nil, ErrDirEmpty
+	}
+	id, ok := value.(*id.ID)
+	if !ok {
+		return "", nil, comerr.ErrAssertFailed
+	}
+
+	path := seqdir.formatPath(id.String('-'))
+	bts, err := os.ReadFile(path)
 	if err != nil {
 		return "", nil, err
 	}
-	fi, err := f.Stat()
-	if err != nil {
+	if err = seqdir.remove(path); err != nil {
 		return "", nil, err
 	}
 
-	bts := bytes.NewBuffer(nil)
-	if _, err = io.Copy(bts, f); err != nil {
-		return "", nil, err
+	return "." + id.String('-'), bytes.NewBuffer(bts), nil
+}
+
+// OpenAndPop reads the earliest file and takes it off the sequence, the file is kept
+// on disk until Remove, so it is listed again after reopening the directory.
+func (seqdir *SequentialDirectory) OpenAndPop(_ string) (string, *bytes.Buffer, error) {
+	value, _ := seqdir.stque.Pop()
+	if value == nil {
+		return "", nil, ErrDirEmpty
+	}
+	id, ok := value.(*id.ID)
+	if !ok {
+		return "", nil, comerr.ErrAssertFailed
 	}
-	f.Close()
 
-	if err = seqdir.Delete(""); err != nil {
+	name := "." + id.String('-')
+	bts, err := os.ReadFile(seqdir.formatPath(id.String('-')))
+	if err != nil {
 		return "", nil, err
 	}
 
-	return fi.Name(), bts, nil
+	return name, bytes.NewBuffer(bts), nil
+}
+
+// Remove deletes the file popped by OpenAndPop.
+func (seqdir *SequentialDirectory) Remove(name string) error {
+	return seqdir.remove(fmt.Sprintf("%s/%s", seqdir.path, name))
 }
 
 func (seqd *SequentialDirectory) Save(_ string, r io.Reader) error {
+	seqd.Lock()
+	defer seqd.Unlock()
+
+	if seqd.maxFiles > 0 && seqd.count >= seqd.maxFiles {
+		return ErrDirFull
+	}
+
 	id := seqd.idflk.NextID()
-	f, err := os.Create(seqd.formatPath(id.String('-')))
+	path := seqd.formatPath(id.String('-'))
+	f, err := os.Create(path)
 	if err != nil {
 		return err
 	}
 	defer f.Close()
 
-	_, err = io.Copy(f, r)
+	n, err := io.Copy(f, r)
+	if seqd.maxBytes > 0 && seqd.size+n > seqd.maxBytes {
+		f.Close()
+		if err = os.Remove(path); err != nil {
+			log.Println(err.Error())
+		}
+
+		return ErrDirFull
+	}
+	seqd.size += n
+	seqd.count++
 	seqd.stque.Push(id)
 
 	return err
@@ -102,14 +164,55 @@ func (seqdir *SequentialDirectory) Delete(_ string) error {
 		return comerr.ErrAssertFailed
 	}
 
-	return os.Remove(seqdir.formatPath(id.String('-')))
+	return seqdir.remove(seqdir.formatPath(id.String('-')))
+}
+
+// DeleteIf deletes the earliest file only if cond reports true for its name, the check and the
+// deletion are done at once so the file is never taken by other callers in between.
+// It returns false if directory is empty or cond reports false.
+func (seqdir *SequentialDirectory) DeleteIf(cond func(name string) bool) (bool, error) {
+	value, _ := seqdir.stque.PopIf(func(value any) bool {
+		id, ok := value.(*id.ID)
+
+		return ok && cond("."+id.String('-'))
+	})
+	if value == nil {
+		return false, nil
+	}
+
+	return true, seqdir.remove(seqdir.formatPath(value.(*id.ID).String('-')))
+}
+
+// Usage returns the total bytes and the number of files on disk.
+func (seqdir *SequentialDirectory) Usage() (int64, int) {
+	seqdir.Lock()
+	defer seqdir.Unlock()
+
+	return seqdir.size, seqdir.count
+}
+
+func (seqdir *SequentialDirectory) remove(path string) error {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	if err = os.Remove(path); err != nil {
+		return err
+	}
+
+	seqdir.Lock()
+	seqdir.size -= fi.Size()
+	seqdir.count--
+	seqdir.Unlock()
+
+	return nil
 }
 
 func (seqdir *SequentialDirectory) formatPath(id string) string {
 	return fmt.Sprintf("%s/.%s", seqdir.path, id)
 }
 
-func OpenSequentialDirectory(path string) (*SequentialDirectory, error) {
+func OpenSequentialDirectory(path string, opts ...SeqDirOption) (*SequentialDirectory, error) {
 	if err := Exist(path); err != nil {
 		if !errors.Is(err, ErrNotDir) {
 			if err = os.MkdirAll(path, 0755); err != nil {
@@ -121,6 +224,9 @@ func OpenSequentialDirectory(path string) (*SequentialDirectory, error) {
 	}
 
 	seqdir := &SequentialDirectory{path: path}
+	for _, opt := range opts {
+		opt(seqdir)
+	}
 	entries, err := seqdir.List()
 	if err != nil {
 		return nil, err
@@ -135,6 +241,12 @@ func OpenSequentialDirectory(path string) (*SequentialDirectory, error) {
 
 	seqdir.stque = set.NewSingleThreadQueue(10)
 	for _, id := range ids {
+		fi, err := os.Stat(seqdir.formatPath(id.String('-')))
+		if err != nil {
+			return nil, err
+		}
+		seqdir.size += fi.Size()
+		seqdir.count++
 		if err = seqdir.stque.Push(id); err != nil {
 			log.Println(err.Error())
 		}
@@ -146,6 +258,10 @@ func OpenSequentialDirectory(path string) (*SequentialDirectory, error) {
 func dirEntriesToIDs(entries []fs.DirEntry) (id.IDs, error) {
 	var ids id.IDs
 	for _, entry := range entries {
+		// skip files kept by callers alongside the sequential files
+		if entry.IsDir() || !strings.HasPrefix(entry.Name(), ".") {
+			continue
+		}
 		if id, err := id.FromString(strings.TrimPrefix(entry.Name(), "."), '-'); err != nil {
 			return nil, err
 		} else {
@@ -23,7 +23,11 @@ import (
 	"encoding/base64"
 	"errors"
 	"fmt"
+	"os"
+	"path/filepath"
 	"strconv"
+	"strings"
+	"sync"
 	"testing"
 )
 
@@ -106,3 +110,95 @@ func TestSeqDirOpenAndDelete(t *testing.T) {
 		fmt.Println(base64.RawStdEncoding.EncodeToString(bts.Bytes()))
 	}
 }
+
+func TestSeqDirOpenAndDeleteConcurrent(t *testing.T) {
+	seqDir, err := OpenSequentialDirectory(t.TempDir())
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	for i := 0; i < 100; i++ {
+		if err = seqDir.Save("", strings.NewReader(fmt.Sprintf("%d", i))); err != nil {
+			t.Fatal(err.Error())
+		}
+	}
+
+	var (
+		mu   sync.Mutex
+		got  = make(map[string]bool)
+		wg   sync.WaitGroup
+		errs = make(chan error, 100)
+	)
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				_, bts, err := seqDir.OpenAndDelete("")
+				if err != nil {
+					if !errors.Is(err, ErrDirEmpty) {
+						errs <- err
+					}
+
+					return
+				}
+				mu.Lock()
+				if got[bts.String()] {
+					errs <- fmt.Errorf("file %s read twice", bts.String())
+				}
+				got[bts.String()] = true
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Fatal(err.Error())
+	}
+	if len(got) != 100 {
+		t.Fatalf("expect 100 files got %d", len(got))
+	}
+	if size, count := seqDir.Usage(); size != 0 || count != 0 {
+		t.Fatalf("expect empty directory got %d bytes %d files", size, count)
+	}
+}
+
+func TestSeqDirDeleteIf(t *testing.T) {
+	path := t.TempDir()
+	seqDir, err := OpenSequentialDirectory(path)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	for i := 0; i < 3; i++ {
+		if err = seqDir.Save("", bytes.NewBufferString(strconv.Itoa(i))); err != nil {
+			t.Fatal(err.Error())
+		}
+	}
+
+	var head string
+	if deleted, err := seqDir.DeleteIf(func(name string) bool {
+		head = name
+
+		return false
+	}); err != nil || deleted {
+		t.Fatalf("expect nothing deleted got %v %v", deleted, err)
+	}
+	if _, n := seqDir.Usage(); n != 3 {
+		t.Fatalf("expect 3 files got %d", n)
+	}
+
+	isHead := func(name string) bool { return name == head }
+	if deleted, err := seqDir.DeleteIf(isHead); err != nil || !deleted {
+		t.Fatalf("expect %s deleted got %v %v", head, deleted, err)
+	}
+	if _, err = os.Stat(filepath.Join(path, head)); !os.IsNotExist(err) {
+		t.Fatalf("expect %s removed from disk", head)
+	}
+	// the head moved on, the same condition deletes nothing
+	if deleted, err := seqDir.DeleteIf(isHead); err != nil || deleted {
+		t.Fatalf("expect nothing deleted got %v %v", deleted, err)
+	}
+	if _, n := seqDir.Usage(); n != 2 {
+		t.Fatalf("expect 2 files got %d", n)
+	}
+}
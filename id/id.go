@@ -99,7 +99,7 @@ func (ids IDs) Len() int {
 }
 
 func (ids IDs) Less(i, j int) bool {
-	return (ids[i].high < ids[j].high) && (ids[i].low < ids[j].low)
+	return ids[i].high < ids[j].high || (ids[i].high == ids[j].high && ids[i].low < ids[j].low)
 }
 
 func (ids IDs) Swap(i, j int) {
@@ -25,21 +25,64 @@ import (
 	"github.com/CodapeWild/devkit/message"
 )
 
-var _ PubAndSub = (*BufferFlush)(nil)
+var (
+	_ PubAndSub             = (*BufferFlush)(nil)
+	_ PubAndSubBatch        = (*BufferFlush)(nil)
+	_ PubStreamAndSubStream = (*BufferFlush)(nil)
+)
+
+// flushEntry is a message buffered along with the context it published with.
+type flushEntry struct {
+	ctx context.Context
+	msg message.Message
+}
 
 type BufferFlush struct {
-	cur, maxSize int
-	flushTick    time.Ticker
-	msgChan      chan SubscribeMessageHandler
-	buffer       []SubscribeMessageHandler
-	handler      SubscribeMessageHandler
-	closer       chan struct{}
+	cur, maxSize  int
+	flushTick     time.Ticker
+	msgChan       chan *flushEntry
+	buffer        []*flushEntry
+	handler       SubscribeMessageHandler
+	batchHandler  SubscribeMessageBatchHandler
+	streamHandler SubscribeMessageStreamHandler
+	stream        chan message.Message // messages flushed to streamHandler
+	streamOut     chan *IOResponse     // responses reported by streamHandler, one for each message
+	retry         RetryPolicy
+	deadLetter    PublishMessage // sink of messages failed after all retries, dropped if nil
+	gate          *drainGate
+	closer        chan struct{}
+}
+
+type BufferFlushOption func(bf *BufferFlush)
+
+// BufferFlushWithRetry redelivers messages failed to flush by policy.
+func BufferFlushWithRetry(policy RetryPolicy) BufferFlushOption {
+	return func(bf *BufferFlush) {
+		bf.retry = policy
+	}
+}
+
+// BufferFlushWithDeadLetter publishes messages still failing after all retries into sink,
+// it could be a FileCache or any PublishMessage.
+func BufferFlushWithDeadLetter(sink PublishMessage) BufferFlushOption {
+	return func(bf *BufferFlush) {
+		bf.deadLetter = sink
+	}
 }
 
 func (bf *BufferFlush) Publish(ctx context.Context, msg message.Message) *IOResponse {
 	if err := ctx.Err(); err != nil {
 		return InputFailed.With(IORespWithMessage(err.Error()))
 	}
+	if bf.batchHandler != nil {
+		if _, ok := msg.(*IOMessage); !ok {
+			return IOWrongMsgType
+		}
+	}
+	if !bf.gate.enter() {
+		return IOClosed
+	}
+	defer bf.gate.leave()
 
 	select {
 	case <-ctx.Done():
@@ -52,20 +95,42 @@ func (bf *BufferFlush) Publish(ctx context.Context, msg message.Message) *IOResp
 		}
 	case <-bf.closer:
 		return IOClosed
-	case bf.msgChan <- bf.handler.BindContext(ctx, msg):
+	case bf.msgChan <- &flushEntry{ctx: ctx, msg: msg}:
 	}
 
 	return InputSuccess
 }
 
+// PublishStream publishes messages received from stream until it is closed,
+// publishing stops at the first failed response and returns it.
+func (bf *BufferFlush) PublishStream(ctx context.Context, stream chan message.Message) *IOResponse {
+	return publishStream(ctx, stream, bf.closer, bf.Publish)
+}
+
 func (bf *BufferFlush) Subscribe(handler SubscribeMessageHandler) error {
 	bf.handler = handler
 
 	return nil
 }
 
+// SubscribeBatch subscribes a handler called once for every flush with all the messages
+// buffered as an IOMessageBatch, only IOMessage is accepted by Publish then.
+func (bf *BufferFlush) SubscribeBatch(handler SubscribeMessageBatchHandler) error {
+	bf.batchHandler = handler
+
+	return nil
+}
+
+// SubscribeStream subscribes a long-lived handler, messages flushed are sent into
+// its stream one by one and each of them expects a response from out.
+func (bf *BufferFlush) SubscribeStream(handler SubscribeMessageStreamHandler) error {
+	bf.streamHandler = handler
+
+	return nil
+}
+
 func (bf *BufferFlush) Start(ctx context.Context) error {
-	if bf.handler == nil {
+	if bf.handler == nil && bf.batchHandler == nil && bf.streamHandler == nil {
 		return ErrIOUncompleted
 	}
 	if err := ctx.Err(); err != nil {
@@ -78,7 +143,23 @@ func (bf *BufferFlush) Start(ctx context.Context) error {
 	default:
 	}
 
+	if bf.handler == nil && bf.batchHandler == nil {
+		bf.stream = make(chan message.Message)
+		bf.streamOut = make(chan *IOResponse)
+		bf.gate.workers.Add(1)
+		go func() {
+			defer bf.gate.workers.Done()
+			bf.streamHandler(ctx, bf.stream, bf.streamOut)
+		}()
+	}
+
+	bf.gate.workers.Add(1)
 	go func() {
+		defer bf.gate.workers.Done()
+		if bf.stream != nil {
+			defer close(bf.stream)
+		}
+
 		for {
 			select {
 			case <-bf.closer:
@@ -88,16 +169,20 @@ func (bf *BufferFlush) Start(ctx context.Context) error {
 					log.Println(err.Error())
 				}
 
+				return
+			case <-bf.gate.draining:
+				bf.drain(ctx)
+
 				return
 			case <-bf.flushTick.C:
 				if bf.cur > 0 {
-					bf.doFlush()
+					bf.doFlush(ctx)
 				}
 			case msg := <-bf.msgChan:
 				bf.buffer[bf.cur] = msg
 				bf.cur++
 				if bf.cur == bf.maxSize {
-					bf.doFlush()
+					bf.doFlush(ctx)
 				}
 			}
 		}
@@ -106,6 +191,14 @@ func (bf *BufferFlush) Start(ctx context.Context) error {
 	return nil
 }
 
+// Shutdown stops accepting publishes, flushes all the messages pending and waits for
+// the worker goroutines to exit, ctx error is returned if ctx done first.
+func (bf *BufferFlush) Shutdown(ctx context.Context) error {
+	defer bf.Close()
+
+	return bf.gate.shutdown(ctx)
+}
+
 func (bf *BufferFlush) Close() {
 	select {
 	case <-bf.closer:
@@ -114,30 +207,102 @@ func (bf *BufferFlush) Close() {
 	}
 }
 
-func (bf *BufferFlush) doFlush() {
-	for _, handler := range bf.buffer {
-		resp := handler(nil, nil)
-		if resp != nil && resp != OutputSuccess {
+// drain flushes the messages left in msgChan and buffer.
+func (bf *BufferFlush) drain(ctx context.Context) {
+	for {
+		select {
+		case entry := <-bf.msgChan:
+			bf.buffer[bf.cur] = entry
+			bf.cur++
+			if bf.cur == bf.maxSize {
+				bf.doFlush(ctx)
+			}
+		default:
+			if bf.cur > 0 {
+				bf.doFlush(ctx)
+			}
+
+			return
+		}
+	}
+}
+
+func (bf *BufferFlush) doFlush(ctx context.Context) {
+	if bf.batchHandler != nil {
+		batch := &IOMessageBatch{List: make([]*IOMessage, bf.cur)}
+		for i, entry := range bf.buffer[:bf.cur] {
+			batch.List[i] = entry.msg.(*IOMessage)
+		}
+		resp := bf.retry.do(ctx, bf.closer, func() *IOResponse { return bf.batchHandler(ctx, batch) })
+		if resp != nil && !resp.IS(OutputSuccess) {
 			log.Printf("do flush failed: %#v", resp)
-			continue
+			for _, msg := range batch.List {
+				bf.sendDeadLetter(ctx, msg)
+			}
+		}
+	} else {
+		for _, entry := range bf.buffer[:bf.cur] {
+			// entry.ctx is usually done once Publish returned, the retries are bounded by the worker instead
+			resp := bf.retry.do(ctx, bf.closer, func() *IOResponse { return bf.handle(entry) })
+			if resp != nil && !resp.IS(OutputSuccess) {
+				log.Printf("do flush failed: %#v", resp)
+				bf.sendDeadLetter(entry.ctx, entry.msg)
+			}
 		}
 	}
 	bf.cur = 0
-	bf.buffer = make([]SubscribeMessageHandler, bf.maxSize)
+	bf.buffer = make([]*flushEntry, bf.maxSize)
 }
 
-func NewBufferFlush(maxSize int, d time.Duration) *BufferFlush {
+// sendDeadLetter publishes msg into dead-letter sink, the cancellation of ctx is ignored
+// since the message is about to be lost otherwise.
+func (bf *BufferFlush) sendDeadLetter(ctx context.Context, msg message.Message) {
+	if bf.deadLetter == nil {
+		return
+	}
+	if resp := bf.deadLetter.Publish(context.WithoutCancel(ctx), msg); !resp.IS(InputSuccess) && !resp.IS(InputEvicted) {
+		log.Printf("publish dead letter failed: %#v", resp)
+	}
+}
+
+// handle delivers message to handler subscribed, streamHandler is used if handler is nil.
+func (bf *BufferFlush) handle(entry *flushEntry) *IOResponse {
+	if bf.handler != nil {
+		return bf.handler(entry.ctx, entry.msg)
+	}
+
+	select {
+	case <-bf.closer:
+		return IOClosed
+	case bf.stream <- entry.msg:
+	}
+	select {
+	case <-bf.closer:
+		return IOClosed
+	case resp := <-bf.streamOut:
+		return resp
+	}
+}
+
+func NewBufferFlush(maxSize int, d time.Duration, opts ...BufferFlushOption) *BufferFlush {
 	cache := maxSize / 2
 	if cache == 0 {
 		maxSize = 20
 		cache = 10
 	}
 
-	return &BufferFlush{
+	bf := &BufferFlush{
 		maxSize:   maxSize,
 		flushTick: *time.NewTicker(d),
-		msgChan:   make(chan SubscribeMessageHandler, cache),
-		buffer:    make([]SubscribeMessageHandler, maxSize),
+		msgChan:   make(chan *flushEntry, cache),
+		buffer:    make([]*flushEntry, maxSize),
+		retry:     RetryWithBackoff(1, 0, 0),
+		gate:      newDrainGate(),
 		closer:    make(chan struct{}),
 	}
+	for _, opt := range opts {
+		opt(bf)
+	}
+
+	return bf
 }
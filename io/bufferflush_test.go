@@ -0,0 +1,229 @@
+/*
+ *   Copyright (c) 2023 CodapeWild
+ *   All rights reserved.
+
+ *   Licensed under the Apache License, Version 2.0 (the "License");
+ *   you may not use this file except in compliance with the License.
+ *   You may obtain a copy of the License at
+
+ *   http://www.apache.org/licenses/LICENSE-2.0
+
+ *   Unless required by applicable law or agreed to in writing, software
+ *   distributed under the License is distributed on an "AS IS" BASIS,
+ *   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *   See the License for the specific language governing permissions and
+ *   limitations under the License.
+ */
+
+package io
+
+import (
+	"context"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/CodapeWild/devkit/message"
+)
+
+func TestBufferFlushStream(t *testing.T) {
+	var (
+		n        = 25
+		received = make(chan message.Message, n)
+	)
+	bf := NewBufferFlush(10, 50*time.Millisecond)
+	bf.SubscribeStream(func(ctx context.Context, stream chan message.Message, out chan *IOResponse) {
+		for msg := range stream {
+			received <- msg
+			out <- OutputSuccess
+		}
+	})
+	if err := bf.Start(context.TODO()); err != nil {
+		t.Fatal(err.Error())
+	}
+	defer bf.Close()
+
+	stream := make(chan message.Message)
+	go func() {
+		for i := 0; i < n; i++ {
+			stream <- NewIOMessage(IOMessageWithPayload([]byte(strconv.Itoa(i))))
+		}
+		close(stream)
+	}()
+	if resp := bf.PublishStream(context.TODO(), stream); !resp.IS(InputSuccess) {
+		t.Fatal(resp.Message)
+	}
+
+	for i := 0; i < n; i++ {
+		select {
+		case msg := <-received:
+			if p := string(msg.(*IOMessage).Payload); p != strconv.Itoa(i) {
+				t.Fatalf("expect message %d got %s", i, p)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("message %d not flushed", i)
+		}
+	}
+}
+
+func TestBufferFlushBatch(t *testing.T) {
+	var (
+		maxSize = 10
+		batches = make(chan message.MessageList, 3)
+	)
+	bf := NewBufferFlush(maxSize, 50*time.Millisecond)
+	bf.SubscribeBatch(func(ctx context.Context, batch message.MessageList) *IOResponse {
+		batches <- batch
+
+		return OutputSuccess
+	})
+	if err := bf.Start(context.TODO()); err != nil {
+		t.Fatal(err.Error())
+	}
+	defer bf.Close()
+
+	for i := 0; i < 25; i++ {
+		if resp := bf.Publish(context.TODO(), NewIOMessage(IOMessageWithPayload([]byte(strconv.Itoa(i))))); !resp.IS(InputSuccess) {
+			t.Fatal(resp.Message)
+		}
+	}
+
+	// two full batches flushed by size and the rest flushed by ticker
+	for _, l := range []int{maxSize, maxSize, 5} {
+		select {
+		case batch := <-batches:
+			if batch.Length() != l {
+				t.Fatalf("expect batch of %d messages got %d", l, batch.Length())
+			}
+		case <-time.After(time.Second):
+			t.Fatal("batch not flushed")
+		}
+	}
+}
+
+type chanPublisher chan message.Message
+
+func (cp chanPublisher) Publish(ctx context.Context, msg message.Message) *IOResponse {
+	cp <- msg
+
+	return InputSuccess
+}
+
+func TestBufferFlushRetryAndDeadLetter(t *testing.T) {
+	var (
+		deadLetter = make(chanPublisher, 1)
+		attempts   = make(map[string]int)
+		succeeded  = make(chan string, 1)
+	)
+	bf := NewBufferFlush(2, 20*time.Millisecond,
+		BufferFlushWithRetry(RetryWithBackoff(3, time.Millisecond, 4*time.Millisecond)),
+		BufferFlushWithDeadLetter(deadLetter))
+	bf.Subscribe(func(ctx context.Context, msg message.Message) *IOResponse {
+		payload := string(msg.(*IOMessage).Payload)
+		// "flaky" succeeds on the last attempt while "broken" never succeeds
+		if attempts[payload]++; payload == "flaky" && attempts[payload] == 3 {
+			succeeded <- payload
+
+			return OutputSuccess
+		}
+
+		return OutputFailed
+	})
+	if err := bf.Start(context.TODO()); err != nil {
+		t.Fatal(err.Error())
+	}
+	defer bf.Close()
+
+	for _, payload := range []string{"flaky", "broken"} {
+		if resp := bf.Publish(context.TODO(), NewIOMessage(IOMessageWithPayload([]byte(payload)))); !resp.IS(InputSuccess) {
+			t.Fatal(resp.Message)
+		}
+	}
+
+	select {
+	case payload := <-succeeded:
+		if payload != "flaky" {
+			t.Fatalf("unexpected message %s succeeded", payload)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("message not retried")
+	}
+	select {
+	case msg := <-deadLetter:
+		if payload := string(msg.(*IOMessage).Payload); payload != "broken" || attempts[payload] != 3 {
+			t.Fatalf("expect broken delivered 3 times got %s %d times", payload, attempts[payload])
+		}
+	case <-time.After(time.Second):
+		t.Fatal("message not sent to dead letter")
+	}
+}
+
+func TestBufferFlushRetryAfterPublishCanceled(t *testing.T) {
+	var (
+		attempts  int
+		succeeded = make(chan int, 1)
+	)
+	bf := NewBufferFlush(1, 20*time.Millisecond, BufferFlushWithRetry(RetryWithBackoff(3, time.Millisecond, time.Millisecond)))
+	bf.Subscribe(func(ctx context.Context, msg message.Message) *IOResponse {
+		if attempts++; attempts == 3 {
+			succeeded <- attempts
+
+			return OutputSuccess
+		}
+
+		return OutputFailed
+	})
+	if err := bf.Start(context.TODO()); err != nil {
+		t.Fatal(err.Error())
+	}
+	defer bf.Close()
+
+	ctx, cancel := context.WithCancel(context.TODO())
+	resp := bf.Publish(ctx, NewIOMessage(IOMessageWithPayload([]byte("flaky"))))
+	cancel()
+	if !resp.IS(InputSuccess) {
+		t.Fatal(resp.Message)
+	}
+
+	select {
+	case n := <-succeeded:
+		if n != 3 {
+			t.Fatalf("expect 3 attempts got %d", n)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("message not retried after publish context canceled")
+	}
+}
+
+func TestBufferFlushShutdown(t *testing.T) {
+	var (
+		n        = 15
+		received = make(chan message.Message, n)
+	)
+	bf := NewBufferFlush(10, time.Hour)
+	bf.Subscribe(func(ctx context.Context, msg message.Message) *IOResponse {
+		received <- msg
+
+		return OutputSuccess
+	})
+	if err := bf.Start(context.TODO()); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	for i := 0; i < n; i++ {
+		if resp := bf.Publish(context.TODO(), NewIOMessage(IOMessageWithPayload([]byte(strconv.Itoa(i))))); !resp.IS(InputSuccess) {
+			t.Fatal(resp.Message)
+		}
+	}
+	ctx, cancel := context.WithTimeout(context.TODO(), time.Second)
+	defer cancel()
+	if err := bf.Shutdown(ctx); err != nil {
+		t.Fatal(err.Error())
+	}
+	if len(received) != n {
+		t.Fatalf("expect %d messages flushed got %d", n, len(received))
+	}
+	if resp := bf.Publish(context.TODO(), NewIOMessage()); !resp.IS(IOClosed) {
+		t.Fatalf("expect closed got %s", resp.Message)
+	}
+}
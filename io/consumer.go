@@ -0,0 +1,299 @@
+/*
+ *   Copyright (c) 2023 CodapeWild
+ *   All rights reserved.
+
+ *   Licensed under the Apache License, Version 2.0 (the "License");
+ *   you may not use this file except in compliance with the License.
+ *   You may obtain a copy of the License at
+
+ *   http://www.apache.org/licenses/LICENSE-2.0
+
+ *   Unless required by applicable law or agreed to in writing, software
+ *   distributed under the License is distributed on an "AS IS" BASIS,
+ *   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *   See the License for the specific language governing permissions and
+ *   limitations under the License.
+ */
+
+package io
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/CodapeWild/devkit/message"
+)
+
+var (
+	_ FetchMessage      = (*FileCacheConsumer)(nil)
+	_ FetchMessageBatch = (*FileCacheConsumer)(nil)
+)
+
+var (
+	ErrInvalidConsumer = errors.New("invalid consumer name")
+	ErrConsumerGroups  = errors.New("cache is read by consumer groups")
+)
+
+const _cursorFileSuffix = ".cursor"
+
+// FileCacheConsumer reads the pages of FileCache at its own pace, the cursor is persisted
+// in cache directory as <name>.cursor and survives restarts.
+type FileCacheConsumer struct {
+	fc    *FileCache
+	name  string
+	page  string       // name of the page reading from, empty if never read
+	index int          // number of messages read in page
+	buf   []*IOMessage // messages in page, loaded lazily
+}
+
+func (cc *FileCacheConsumer) Fetch(ctx context.Context) (msg message.Message, resp *IOResponse) {
+	resp = cc.fc.waitForData(ctx, cc.fc.blockingFetch, func() *IOResponse {
+		msg, resp = cc.fetch(ctx)
+
+		return resp
+	})
+
+	return
+}
+
+func (cc *FileCacheConsumer) fetch(ctx context.Context) (message.Message, *IOResponse) {
+	if err := ctx.Err(); err != nil {
+		return nil, InputFailed.With(IORespWithMessage(err.Error()))
+	}
+	select {
+	case <-cc.fc.closer:
+		return nil, IOClosed
+	default:
+	}
+
+	cc.fc.Lock()
+	defer cc.fc.Unlock()
+
+	if resp := cc.prepare(); resp != nil {
+		return nil, resp
+	}
+	msg := cc.buf[cc.index]
+	cc.index++
+	cc.saveCursor()
+
+	return msg, OutputSuccess
+}
+
+// FetchBatch returns the messages left in the page reading from or the whole next page.
+func (cc *FileCacheConsumer) FetchBatch(ctx context.Context) (batch message.MessageList, resp *IOResponse) {
+	resp = cc.fc.waitForData(ctx, cc.fc.blockingFetch, func() *IOResponse {
+		batch, resp = cc.fetchBatch(ctx)
+
+		return resp
+	})
+
+	return
+}
+
+func (cc *FileCacheConsumer) fetchBatch(ctx context.Context) (message.MessageList, *IOResponse) {
+	if err := ctx.Err(); err != nil {
+		return nil, InputFailed.With(IORespWithMessage(err.Error()))
+	}
+	select {
+	case <-cc.fc.closer:
+		return nil, IOClosed
+	default:
+	}
+
+	cc.fc.Lock()
+	defer cc.fc.Unlock()
+
+	if resp := cc.prepare(); resp != nil {
+		return nil, resp
+	}
+	batch := &IOMessageBatch{List: cc.buf[cc.index:]}
+	cc.index = len(cc.buf)
+	cc.saveCursor()
+
+	return batch, OutputSuccess
+}
+
+// prepare makes sure there is unread message in buf, pages are moved forward if necessary.
+func (cc *FileCacheConsumer) prepare() *IOResponse {
+	// reload the page after restart, the page may be dropped by quota eviction
+	if cc.buf == nil && cc.page != "" {
+		if list, err := cc.fc.loadPage(cc.page); err == nil {
+			cc.buf = list
+		} else if !errors.Is(err, os.ErrNotExist) {
+			return OutputFailed.With(IORespWithMessage(err.Error()))
+		}
+	}
+
+	for cc.buf == nil || cc.index >= len(cc.buf) {
+		next, err := cc.fc.pageAfter(cc.page)
+		if err != nil {
+			return OutputFailed.With(IORespWithMessage(err.Error()))
+		}
+		if next == "" {
+			// page out messages in writePageBuf so all consumers are able to read them
+			if err = cc.fc.pauseWriting(); err != nil {
+				return OutputFailed.With(IORespWithMessage(err.Error()))
+			}
+			list, err := cc.fc.takeWritePage(true)
+			cc.fc.resumeWriting()
+			if err != nil {
+				return OutputFailed.With(IORespWithMessage(err.Error()))
+			}
+			if len(list) == 0 {
+				return OutputEmpty
+			}
+
+			continue
+		}
+
+		if cc.buf, err = cc.fc.loadPage(next); err != nil {
+			return OutputFailed.With(IORespWithMessage(err.Error()))
+		}
+		cc.page = next
+		cc.index = 0
+		cc.saveCursor()
+		cc.fc.collectPages()
+	}
+
+	return nil
+}
+
+func (cc *FileCacheConsumer) saveCursor() {
+	if err := saveState(cc.fc.path, cc.name+_cursorFileSuffix, fmt.Sprintf("%s %d", cc.page, cc.index)); err != nil {
+		log.Println(err.Error())
+	}
+}
+
+// Consumer returns the consumer registered by name, a new consumer starts reading
+// from the earliest page on disk. FileCache.Fetch and FileCache.FetchBatch stop working
+// once any consumer registered.
+func (fc *FileCache) Consumer(name string) (*FileCacheConsumer, error) {
+	// names with leading dot are taken by the page files of SequentialDirectory
+	if name == "" || strings.HasPrefix(name, ".") || strings.ContainsAny(name, `/\`) {
+		return nil, ErrInvalidConsumer
+	}
+
+	fc.Lock()
+	defer fc.Unlock()
+
+	if cc, ok := fc.consumers[name]; ok {
+		return cc, nil
+	}
+
+	cc := &FileCacheConsumer{fc: fc, name: name}
+	if err := saveState(fc.path, name+_cursorFileSuffix, " 0"); err != nil {
+		return nil, err
+	}
+	fc.consumers[name] = cc
+
+	return cc, nil
+}
+
+// RemoveConsumer unregisters consumer so the pages are no longer kept for it.
+func (fc *FileCache) RemoveConsumer(name string) error {
+	fc.Lock()
+	defer fc.Unlock()
+
+	if _, ok := fc.consumers[name]; !ok {
+		return ErrInvalidConsumer
+	}
+	delete(fc.consumers, name)
+	if err := os.Remove(fmt.Sprintf("%s/%s%s", fc.path, name, _cursorFileSuffix)); err != nil {
+		return err
+	}
+	fc.collectPages()
+
+	return nil
+}
+
+// collectPages deletes the pages all consumers have moved past.
+func (fc *FileCache) collectPages() {
+	var min string
+	for _, cc := range fc.consumers {
+		if cc.page == "" {
+			return
+		}
+		if min == "" || comparePages(cc.page, min) < 0 {
+			min = cc.page
+		}
+	}
+	if min == "" {
+		return
+	}
+
+	// the writer may evict pages concurrently, so the head is only deleted if it is still before min
+	for {
+		deleted, err := fc.seqDir.DeleteIf(func(name string) bool { return comparePages(name, min) < 0 })
+		if err != nil {
+			log.Println(err.Error())
+
+			return
+		}
+		if !deleted {
+			return
+		}
+		fc.notifySpaceFreed()
+	}
+}
+
+// pageAfter returns the earliest page saved after page, empty if not found.
+func (fc *FileCache) pageAfter(page string) (string, error) {
+	pages, err := listPages(fc.path)
+	if err != nil {
+		return "", err
+	}
+	for _, p := range pages {
+		if page == "" || comparePages(p, page) > 0 {
+			return p, nil
+		}
+	}
+
+	return "", nil
+}
+
+func (fc *FileCache) loadPage(page string) ([]*IOMessage, error) {
+	bts, err := os.ReadFile(fmt.Sprintf("%s/%s", fc.path, page))
+	if err != nil {
+		return nil, err
+	}
+	batch := &IOMessageBatch{}
+	if err = batch.Decode(bts); err != nil {
+		return nil, err
+	}
+
+	return batch.List, nil
+}
+
+// loadConsumers restores the consumers registered before from cursor files.
+func (fc *FileCache) loadConsumers() error {
+	entries, err := os.ReadDir(fc.path)
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range entries {
+		name, ok := strings.CutSuffix(entry.Name(), _cursorFileSuffix)
+		if !ok || entry.IsDir() {
+			continue
+		}
+		state, err := loadState(fc.path, entry.Name())
+		if err != nil {
+			return err
+		}
+
+		cc := &FileCacheConsumer{fc: fc, name: name}
+		if page, index, ok := strings.Cut(state, " "); ok {
+			cc.page = page
+			if _, err = fmt.Sscanf(index, "%d", &cc.index); err != nil {
+				return err
+			}
+		}
+		fc.consumers[name] = cc
+	}
+
+	return nil
+}
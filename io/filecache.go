@@ -21,16 +21,22 @@ import (
 	"bytes"
 	"context"
 	"errors"
+	"fmt"
 	"log"
 	"os"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/CodapeWild/devkit/directory"
 	"github.com/CodapeWild/devkit/message"
 	"google.golang.org/protobuf/proto"
 )
 
-var _ PubPubBatchAndFetchFetchBatch = (*FileCache)(nil)
+var (
+	_ PubPubBatchAndFetchFetchBatch = (*FileCache)(nil)
+	_ PubStreamAndSubStream         = (*FileCache)(nil)
+)
 
 type FileCache struct {
 	sync.Mutex
@@ -43,9 +49,74 @@ type FileCache struct {
 	readIndex                 int          // indicating the index position for reading start from 0 to pageSize-1
 	writeIndex                int          // indicating the index position for writing start from 0 to pageSize-1
 	writePause, writeResume   chan struct{}
+	journalPolicy             *JournalSyncPolicy            // write-ahead journal disabled if nil
+	journal                   *journal                      // journal of messages in writePageBuf
+	ackTimeout                time.Duration                 // ack mode enabled if greater than 0
+	readPage                  *pageLease                    // lease of the page readPageBuf loaded from in ack mode
+	leases                    map[any]*lease                // leases of the messages or batches fetched but not acked yet
+	leaseSeq                  uint64                        // sequence of leases in the order fetched
+	redelivery                []*leasedMessage              // messages expired before acked
+	maxBytes                  int64                         // disk quota in bytes, no limit if 0
+	maxPages                  int                           // disk quota in pages, no limit if 0
+	evictPolicy               EvictionPolicy                // what to do when disk quota hit
+	evicted                   int64                         // number of messages evicted not reported yet
+	spaceFreed                chan struct{}                 // notify writing thread blocked by quota
+	consumers                 map[string]*FileCacheConsumer // consumer groups reading pages at own pace
+	blockingFetch             bool                          // Fetch waits for data published instead of returning OutputEmpty
+	readyMu                   sync.Mutex
+	dataReady                 chan struct{} // closed by writing thread to wake readers up
+	readyWatched              bool          // dataReady is being waited on
+	streamHandler             SubscribeMessageStreamHandler
+	gate                      *drainGate
 	closer                    chan struct{}
 }
 
+// EvictionPolicy decides which messages to shed when FileCache hits disk quota.
+type EvictionPolicy int
+
+const (
+	EvictDropOldest EvictionPolicy = iota + 1 // drop the earliest pages on disk
+	EvictDropNewest                           // drop the page being paged out
+	EvictBlock                                // block publishers until pages fetched
+)
+
+type FileCacheOption func(fc *FileCache)
+
+// FileCacheWithJournal records every message published into a write-ahead journal
+// before it is paged out to disk, the journal is replayed in OpenFileCache after crash.
+func FileCacheWithJournal(policy JournalSyncPolicy) FileCacheOption {
+	return func(fc *FileCache) {
+		fc.journalPolicy = &policy
+	}
+}
+
+// FileCacheWithQuota caps the pages saved on disk by total bytes and page count, zero means no limit,
+// the messages evicted by policy are reported by the following Publish returning InputEvicted.
+func FileCacheWithQuota(maxBytes int64, maxPages int, policy EvictionPolicy) FileCacheOption {
+	return func(fc *FileCache) {
+		fc.maxBytes = maxBytes
+		fc.maxPages = maxPages
+		fc.evictPolicy = policy
+	}
+}
+
+// FileCacheWithBlockingFetch makes Fetch and FetchBatch wait for data published
+// instead of returning OutputEmpty, bounded by the context passed in.
+func FileCacheWithBlockingFetch() FileCacheOption {
+	return func(fc *FileCache) {
+		fc.blockingFetch = true
+	}
+}
+
+// FileCacheWithAck turns on ack mode, messages fetched stay reserved until acked by Ack or AckBatch,
+// they are redelivered if not acked in timeout or the cache is reopened.
+func FileCacheWithAck(timeout time.Duration) FileCacheOption {
+	return func(fc *FileCache) {
+		fc.ackTimeout = timeout
+		fc.leases = make(map[any]*lease)
+	}
+}
+
 func (fc *FileCache) Publish(ctx context.Context, msg message.Message) *IOResponse {
 	if err := ctx.Err(); err != nil {
 		return InputFailed.With(IORespWithMessage(err.Error()))
@@ -54,6 +125,10 @@ func (fc *FileCache) Publish(ctx context.Context, msg message.Message) *IORespon
 	if !ok {
 		return IOWrongMsgType
 	}
+	if !fc.gate.enter() {
+		return IOClosed
+	}
+	defer fc.gate.leave()
 
 	select {
 	case <-ctx.Done():
@@ -69,7 +144,7 @@ func (fc *FileCache) Publish(ctx context.Context, msg message.Message) *IORespon
 	case fc.writeChan <- iomsg:
 	}
 
-	return InputSuccess
+	return fc.inputResponse()
 }
 
 func (fc *FileCache) PublishBatch(ctx context.Context, batch message.MessageList) *IOResponse {
@@ -80,6 +155,10 @@ func (fc *FileCache) PublishBatch(ctx context.Context, batch message.MessageList
 	if !ok {
 		return IOWrongMsgType
 	}
+	if !fc.gate.enter() {
+		return IOClosed
+	}
+	defer fc.gate.leave()
 
 	for _, iomsg := range iomsgbatch.List {
 		select {
@@ -97,10 +176,46 @@ func (fc *FileCache) PublishBatch(ctx context.Context, batch message.MessageList
 		}
 	}
 
+	return fc.inputResponse()
+}
+
+// PublishStream publishes messages received from stream until it is closed,
+// publishing stops at the first failed response and returns it.
+func (fc *FileCache) PublishStream(ctx context.Context, stream chan message.Message) *IOResponse {
+	return publishStream(ctx, stream, fc.closer, fc.Publish)
+}
+
+// SubscribeStream subscribes a long-lived handler started along with the cache, the messages cached
+// are fetched into its stream one by one and each of them expects a response from out,
+// in ack mode the message is acked once OutputSuccess reported otherwise redelivered after timeout.
+func (fc *FileCache) SubscribeStream(handler SubscribeMessageStreamHandler) error {
+	fc.streamHandler = handler
+
+	return nil
+}
+
+// inputResponse reports the messages evicted since last publishing.
+func (fc *FileCache) inputResponse() *IOResponse {
+	if n := atomic.SwapInt64(&fc.evicted, 0); n != 0 {
+		return NewIOResponse(IOStatus_IEvicted, IORespWithMessage(fmt.Sprintf("%d messages evicted", n)))
+	}
+
 	return InputSuccess
 }
 
-func (fc *FileCache) Fetch(ctx context.Context) (message.Message, *IOResponse) {
+// Fetch returns messages one by one in the order of readPageBuf, SequentialDirectory, writePageBuf,
+// in ack mode the messages expired before acked are returned first.
+func (fc *FileCache) Fetch(ctx context.Context) (msg message.Message, resp *IOResponse) {
+	resp = fc.waitForData(ctx, fc.blockingFetch, func() *IOResponse {
+		msg, resp = fc.fetch(ctx)
+
+		return resp
+	})
+
+	return
+}
+
+func (fc *FileCache) fetch(ctx context.Context) (message.Message, *IOResponse) {
 	if err := ctx.Err(); err != nil {
 		return nil, InputFailed.With(IORespWithMessage(err.Error()))
 	}
@@ -113,42 +228,34 @@ func (fc *FileCache) Fetch(ctx context.Context) (message.Message, *IOResponse) {
 	fc.Lock()
 	defer fc.Unlock()
 
+	if len(fc.consumers) != 0 {
+		return nil, OutputFailed.With(IORespWithMessage(ErrConsumerGroups.Error()))
+	}
+	if fc.ackTimeout > 0 {
+		fc.expireLeases()
+		if len(fc.redelivery) != 0 {
+			leased := fc.redelivery[0]
+			fc.redelivery = fc.redelivery[1:]
+			fc.lease(leased.msg, []*leasedMessage{leased})
+
+			return leased.msg, OutputSuccess
+		}
+	}
+
 	// load new page from SequentialDirectory(disk) if empty load data from writePageBuf into readPageBuf
-	if (fc.readIndex == fc.pageSize-1) || (fc.readPageBuf[fc.readIndex] == nil) {
-		fname, bts, err := fc.seqDir.OpenAndDelete("")
-		if err != nil {
-			// directory is empty try to load data from writePageBuf
-			if errors.Is(err, directory.ErrDirEmpty) {
-				if fc.writeIndex != 0 {
-					fc.writePause <- struct{}{}
-					fc.readPageBuf = fc.writePageBuf
-					fc.readIndex = -1
-					fc.writePageBuf = make([]*IOMessage, fc.pageSize)
-					fc.writeIndex = -1
-					fc.writeResume <- struct{}{}
-				} else {
-					return nil, OutputEmpty
-				}
-			} else {
-				return nil, OutputFailed.With(IORespWithMessage(err.Error()))
-			}
-		} else {
-			var batch = &IOMessageBatch{}
-			if err = batch.Decode(bts.Bytes()); err != nil {
-				return nil, OutputFailed.With(IORespWithMessage(err.Error()))
-			}
-			if batch.Length() != fc.pageSize {
-				return nil, OutputFailed.With(IORespWithMessage("wrong message batch length"))
-			}
-			fc.readPageName = fname
-			fc.readPageBuf = batch.List
-			fc.readIndex = -1
+	for fc.readIndex+1 == len(fc.readPageBuf) || fc.readPageBuf[fc.readIndex+1] == nil {
+		if resp := fc.loadReadPage(); resp != nil {
+			return nil, resp
 		}
 	}
 
 	fc.readIndex++
+	msg := fc.readPageBuf[fc.readIndex]
+	if fc.ackTimeout > 0 {
+		fc.lease(msg, []*leasedMessage{{msg: msg, page: fc.readPage}})
+	}
 
-	return fc.readPageBuf[fc.readIndex], OutputSuccess
+	return msg, OutputSuccess
 }
 
 // FetchBatch returns messages batch the number of message count depends:
@@ -157,8 +264,19 @@ func (fc *FileCache) Fetch(ctx context.Context) (message.Message, *IOResponse) {
 // - readPageBuf not empty and SequentialDirectory empty and writePageBuf not empty then return readIndex + writeIndex
 // - readPageBuf empty and SequentialDirectory empty and writePageBuf not empty then return writeIndex
 // - readPageBuf empty and SequentialDirectory empty and writePageBuf empty return 0
-// the order of returning data is readPageBuf, SequentialDirectory, writePageBuf
-func (fc *FileCache) FetchBatch(ctx context.Context) (message.MessageList, *IOResponse) {
+// the order of returning data is readPageBuf, SequentialDirectory, writePageBuf,
+// in ack mode the messages expired before acked are put in front of the batch.
+func (fc *FileCache) FetchBatch(ctx context.Context) (batch message.MessageList, resp *IOResponse) {
+	resp = fc.waitForData(ctx, fc.blockingFetch, func() *IOResponse {
+		batch, resp = fc.fetchBatch(ctx)
+
+		return resp
+	})
+
+	return
+}
+
+func (fc *FileCache) fetchBatch(ctx context.Context) (message.MessageList, *IOResponse) {
 	if err := ctx.Err(); err != nil {
 		return nil, InputFailed.With(IORespWithMessage(err.Error()))
 	}
@@ -172,39 +290,262 @@ func (fc *FileCache) FetchBatch(ctx context.Context) (message.MessageList, *IORe
 	fc.Lock()
 	defer fc.Unlock()
 
-	var list []*IOMessage = fc.readPageBuf
-	_, bts, err := fc.seqDir.OpenAndDelete("")
-	if err != nil {
-		if errors.Is(err, directory.ErrDirEmpty) {
-			if fc.writeIndex != -1 {
-				fc.writePause <- struct{}{}
-				list = append(list, fc.writePageBuf[:fc.writeIndex+1]...)
-				fc.writePageBuf = make([]*IOMessage, fc.pageSize)
-				fc.writeIndex = -1
-				fc.writeResume <- struct{}{}
-
-				fc.readPageBuf = make([]*IOMessage, fc.pageSize)
-				fc.readIndex = -1
-
-				return &IOMessageBatch{List: list}, OutputSuccess
+	if len(fc.consumers) != 0 {
+		return nil, OutputFailed.With(IORespWithMessage(ErrConsumerGroups.Error()))
+	}
+
+	var leased []*leasedMessage
+	if fc.ackTimeout > 0 {
+		fc.expireLeases()
+		leased = fc.redelivery
+		fc.redelivery = nil
+	}
+	takeReadPage := func() {
+		for fc.readIndex+1 < len(fc.readPageBuf) && fc.readPageBuf[fc.readIndex+1] != nil {
+			fc.readIndex++
+			leased = append(leased, &leasedMessage{msg: fc.readPageBuf[fc.readIndex], page: fc.readPage})
+		}
+	}
+
+	takeReadPage()
+	if resp := fc.loadReadPage(); resp == nil {
+		takeReadPage()
+	} else if !resp.IS(OutputEmpty) {
+		return nil, resp
+	}
+	if len(leased) == 0 {
+		return nil, OutputEmpty
+	}
+
+	batch := &IOMessageBatch{List: make([]*IOMessage, len(leased))}
+	for i := range leased {
+		batch.List[i] = leased[i].msg
+	}
+	if fc.ackTimeout > 0 {
+		fc.lease(batch, leased)
+	}
+
+	return batch, OutputSuccess
+}
+
+// waitForData calls fetch again every time new data published until it is not empty,
+// fetch is called only once if block is false.
+func (fc *FileCache) waitForData(ctx context.Context, block bool, fetch func() *IOResponse) *IOResponse {
+	for {
+		ready := fc.watchDataReady()
+		resp := fetch()
+		if !block || !resp.IS(OutputEmpty) {
+			return resp
+		}
+
+		// leases expired are not notified by writing thread
+		var recheck <-chan time.Time
+		if fc.ackTimeout > 0 {
+			recheck = time.After(fc.ackTimeout)
+		}
+		select {
+		case <-ctx.Done():
+			if _, ok := ctx.Deadline(); ok {
+				return OutputTimeout
 			} else {
-				return nil, OutputEmpty
+				return OutputFailed.With(IORespWithMessage(ctx.Err().Error()))
 			}
-		} else {
-			return nil, OutputFailed.With(IORespWithMessage(err.Error()))
+		case <-fc.closer:
+			return IOClosed
+		case <-ready:
+		case <-recheck:
 		}
 	}
+}
+
+func (fc *FileCache) watchDataReady() chan struct{} {
+	fc.readyMu.Lock()
+	defer fc.readyMu.Unlock()
+
+	fc.readyWatched = true
+
+	return fc.dataReady
+}
+
+// notifyDataReady wakes up all readers waiting for data.
+func (fc *FileCache) notifyDataReady() {
+	fc.readyMu.Lock()
+	defer fc.readyMu.Unlock()
+
+	if fc.readyWatched {
+		close(fc.dataReady)
+		fc.dataReady = make(chan struct{})
+		fc.readyWatched = false
+	}
+}
+
+// loadReadPage loads the earliest page from SequentialDirectory into readPageBuf,
+// writePageBuf is taken if directory is empty.
+func (fc *FileCache) loadReadPage() *IOResponse {
+	fname, bts, err := fc.popPage()
+	if errors.Is(err, directory.ErrDirEmpty) {
+		var list []*IOMessage
+		if fname, bts, list, err = fc.popOrTakeWritePage(); err == nil && bts == nil {
+			if len(list) == 0 {
+				return OutputEmpty
+			}
+			fc.readPageName = ""
+			fc.readPageBuf = list
+			fc.readIndex = -1
+
+			return nil
+		}
+	}
+	if err != nil {
+		return OutputFailed.With(IORespWithMessage(err.Error()))
+	}
 
 	batch := &IOMessageBatch{}
 	if err = batch.Decode(bts.Bytes()); err != nil {
-		return nil, OutputFailed.With(IORespWithMessage(err.Error()))
+		return OutputFailed.With(IORespWithMessage(err.Error()))
 	}
-	list = append(list, batch.List...)
-
-	fc.readPageBuf = make([]*IOMessage, fc.pageSize)
+	fc.readPageName = fname
+	fc.readPageBuf = batch.List
 	fc.readIndex = -1
+	if fc.ackTimeout > 0 {
+		fc.readPage = &pageLease{name: fname, pending: batch.Length()}
+	}
+
+	return nil
+}
+
+// popPage takes the earliest page off SequentialDirectory, the page file is kept until acked in ack mode.
+func (fc *FileCache) popPage() (string, *bytes.Buffer, error) {
+	if fc.ackTimeout > 0 {
+		return fc.seqDir.OpenAndPop("")
+	}
+
+	fname, bts, err := fc.seqDir.OpenAndDelete("")
+	if err == nil {
+		fc.notifySpaceFreed()
+	}
+
+	return fname, bts, err
+}
+
+// popOrTakeWritePage pops page again with writing thread paused since a page may be saved
+// right before pausing, writePageBuf is taken if directory is still empty.
+func (fc *FileCache) popOrTakeWritePage() (string, *bytes.Buffer, []*IOMessage, error) {
+	if err := fc.pauseWriting(); err != nil {
+		return "", nil, nil, err
+	}
+	defer fc.resumeWriting()
+
+	fname, bts, err := fc.popPage()
+	if !errors.Is(err, directory.ErrDirEmpty) {
+		return fname, bts, nil, err
+	}
+
+	// leased messages must be backed by page file to be redelivered after restart
+	list, err := fc.takeWritePage(fc.ackTimeout > 0)
+	if err != nil || len(list) == 0 || fc.ackTimeout == 0 {
+		return "", nil, list, err
+	}
+	fname, bts, err = fc.popPage()
+
+	return fname, bts, nil, err
+}
+
+func (fc *FileCache) pauseWriting() error {
+	select {
+	case <-fc.closer:
+		return ErrIOClosed
+	case fc.writePause <- struct{}{}:
+		return nil
+	}
+}
 
-	return &IOMessageBatch{List: list}, OutputSuccess
+func (fc *FileCache) resumeWriting() {
+	fc.writeResume <- struct{}{}
+}
+
+// takeWritePage takes messages out of writePageBuf with writing thread paused,
+// the messages are saved into SequentialDirectory before returning if persist is true.
+func (fc *FileCache) takeWritePage(persist bool) ([]*IOMessage, error) {
+	if fc.writeIndex == -1 {
+		return nil, nil
+	}
+
+	list := fc.writePageBuf[:fc.writeIndex+1]
+	if persist {
+		if err := fc.savePage(list); err != nil {
+			return nil, err
+		}
+	}
+	fc.writePageBuf = make([]*IOMessage, fc.pageSize)
+	fc.writeIndex = -1
+	fc.resetJournal()
+
+	return list, nil
+}
+
+// savePageWithQuota saves a page full of messages and applies eviction policy if disk quota hit.
+func (fc *FileCache) savePageWithQuota(list []*IOMessage) error {
+	for {
+		err := fc.savePage(list)
+		if !errors.Is(err, directory.ErrDirFull) {
+			return err
+		}
+
+		switch fc.evictPolicy {
+		case EvictDropOldest:
+			// OpenAndDelete pops the page atomically so it never races with readers popping pages
+			_, bts, err := fc.seqDir.OpenAndDelete("")
+			if err == nil {
+				batch := &IOMessageBatch{}
+				if err = batch.Decode(bts.Bytes()); err != nil {
+					log.Println(err.Error())
+				}
+				atomic.AddInt64(&fc.evicted, int64(batch.Length()))
+
+				continue
+			}
+			if !errors.Is(err, directory.ErrDirEmpty) {
+				return err
+			}
+			// quota taken by pages not acked yet, nothing older to drop
+			atomic.AddInt64(&fc.evicted, int64(len(list)))
+
+			return nil
+		case EvictBlock:
+			select {
+			case <-fc.closer:
+				return ErrIOClosed
+			case <-fc.spaceFreed:
+			case <-fc.writePause:
+				<-fc.writeResume
+				if fc.writeIndex == -1 {
+					return nil
+				}
+			}
+		default:
+			atomic.AddInt64(&fc.evicted, int64(len(list)))
+
+			return nil
+		}
+	}
+}
+
+// notifySpaceFreed wakes writing thread blocked by disk quota.
+func (fc *FileCache) notifySpaceFreed() {
+	select {
+	case fc.spaceFreed <- struct{}{}:
+	default:
+	}
+}
+
+func (fc *FileCache) savePage(list []*IOMessage) error {
+	bts, err := proto.Marshal(&IOMessageBatch{List: list})
+	if err != nil {
+		return err
+	}
+
+	return fc.seqDir.Save("", bytes.NewBuffer(bts))
 }
 
 func (fc *FileCache) Start(ctx context.Context) error {
@@ -218,7 +559,17 @@ func (fc *FileCache) Start(ctx context.Context) error {
 	}
 
 	// start write thread
+	fc.gate.workers.Add(1)
 	go func() {
+		defer fc.gate.workers.Done()
+
+		var syncTick <-chan time.Time
+		if fc.journal != nil && fc.journal.policy.interval > 0 {
+			ticker := time.NewTicker(fc.journal.policy.interval)
+			defer ticker.Stop()
+			syncTick = ticker.C
+		}
+
 	BEFORE_EXITS:
 		for {
 			select {
@@ -229,11 +580,22 @@ func (fc *FileCache) Start(ctx context.Context) error {
 					log.Println(err.Error())
 				}
 				break BEFORE_EXITS
+			case <-fc.gate.draining:
+				fc.drain()
+				// stop readers before writing buffers back
+				fc.Close()
+				break BEFORE_EXITS
 			case <-fc.writePause:
 				<-fc.writeResume
+			case <-syncTick:
+				if err := fc.journal.sync(); err != nil {
+					log.Println(err.Error())
+				}
 			case msg := <-fc.writeChan:
 				if err := fc.writeRoutine(msg); err != nil {
 					log.Println(err.Error())
+				} else {
+					fc.notifyDataReady()
 				}
 			}
 		}
@@ -243,9 +605,98 @@ func (fc *FileCache) Start(ctx context.Context) error {
 		}
 	}()
 
+	if fc.streamHandler != nil {
+		fc.gate.workers.Add(1)
+		go func() {
+			defer fc.gate.workers.Done()
+			fc.streamRoutine(ctx)
+		}()
+	}
+
 	return nil
 }
 
+// streamRoutine feeds streamHandler with messages fetched and collects the responses.
+func (fc *FileCache) streamRoutine(ctx context.Context) {
+	stream := make(chan message.Message)
+	out := make(chan *IOResponse)
+	defer close(stream)
+	go fc.streamHandler(ctx, stream, out)
+
+	for {
+		var msg message.Message
+		resp := fc.waitForData(ctx, true, func() (resp *IOResponse) {
+			msg, resp = fc.fetch(ctx)
+
+			return
+		})
+		if resp.IS(IOClosed) || ctx.Err() != nil {
+			return
+		}
+		if !resp.IS(OutputSuccess) {
+			log.Printf("fetch for stream failed: %#v", resp)
+
+			select {
+			case <-fc.closer:
+				return
+			case <-ctx.Done():
+				return
+			case <-fc.watchDataReady():
+			}
+
+			continue
+		}
+
+		select {
+		case <-fc.closer:
+			return
+		case <-ctx.Done():
+			return
+		case stream <- msg:
+		}
+		select {
+		case <-fc.closer:
+			return
+		case <-ctx.Done():
+			return
+		case resp = <-out:
+		}
+
+		if resp != nil && !resp.IS(OutputSuccess) {
+			log.Printf("stream handler failed: %#v", resp)
+		} else if err := fc.Ack(msg); err != nil {
+			log.Println(err.Error())
+		}
+	}
+}
+
+// drain writes the messages left in writeChan, readers still fetch meanwhile
+// so writing blocked by quota goes on.
+func (fc *FileCache) drain() {
+	for {
+		select {
+		case msg := <-fc.writeChan:
+			if err := fc.writeRoutine(msg); err != nil {
+				log.Println(err.Error())
+			} else {
+				fc.notifyDataReady()
+			}
+		case <-fc.writePause:
+			<-fc.writeResume
+		default:
+			return
+		}
+	}
+}
+
+// Shutdown stops accepting publishes, persists all the messages pending onto disk and waits for
+// the writing thread and stream routine to exit, ctx error is returned if ctx done first.
+func (fc *FileCache) Shutdown(ctx context.Context) error {
+	defer fc.Close()
+
+	return fc.gate.shutdown(ctx)
+}
+
 func (fc *FileCache) Close() {
 	select {
 	case <-fc.closer:
@@ -255,63 +706,84 @@ func (fc *FileCache) Close() {
 }
 
 func (fc *FileCache) writeRoutine(message *IOMessage) error {
+	if fc.journal != nil {
+		if err := fc.journal.append(message); err != nil {
+			return err
+		}
+	}
+
 	fc.writeIndex++
 	fc.writePageBuf[fc.writeIndex] = message
 	// move data into SequentialDirectory(disk)
 	if fc.writeIndex == fc.pageSize-1 {
-		if bts, err := proto.Marshal(&IOMessageBatch{List: fc.writePageBuf}); err != nil {
+		if err := fc.savePageWithQuota(fc.writePageBuf); err != nil {
 			return err
-		} else {
-			if err = fc.seqDir.Save("", bytes.NewBuffer(bts)); err != nil {
-				return err
-			}
+		}
+		if fc.writeIndex == -1 {
+			// taken by reader while blocked by quota
+			return nil
 		}
 		fc.writePageBuf = make([]*IOMessage, fc.pageSize)
 		fc.writeIndex = -1
+		fc.resetJournal()
 	}
 
 	return nil
 }
 
-// bufferToDisk writes data in readPageBuf and writePageBuf back to directory(disk)
-func (fc *FileCache) bufferToDisk() error {
-	if fc.readIndex != -1 && fc.readPageName != "" {
-		bts, err := proto.Marshal(&IOMessageBatch{List: fc.readPageBuf})
-		if err != nil {
-			return err
+// resetJournal clears journal after writePageBuf has been paged out or taken by reader.
+func (fc *FileCache) resetJournal() {
+	if fc.journal != nil {
+		if err := fc.journal.reset(); err != nil {
+			log.Println(err.Error())
 		}
-		if err = os.WriteFile(fc.readPageName, bts, 0644); err != nil {
+	}
+}
+
+// bufferToDisk writes data unread in readPageBuf and writePageBuf back to directory(disk),
+// writePageBuf is left in journal if journal enabled, readPageBuf is still on disk in ack mode.
+func (fc *FileCache) bufferToDisk() error {
+	fc.Lock()
+	defer fc.Unlock()
+
+	var unread []*IOMessage
+	for i := fc.readIndex + 1; i < len(fc.readPageBuf) && fc.readPageBuf[i] != nil; i++ {
+		unread = append(unread, fc.readPageBuf[i])
+	}
+	if len(unread) != 0 && fc.ackTimeout == 0 {
+		if fc.readPageName != "" {
+			bts, err := proto.Marshal(&IOMessageBatch{List: unread})
+			if err != nil {
+				return err
+			}
+			if err = os.WriteFile(fmt.Sprintf("%s/%s", fc.path, fc.readPageName), bts, 0644); err != nil {
+				return err
+			}
+		} else if err := fc.savePage(unread); err != nil {
 			return err
 		}
 	}
+	if fc.journal != nil {
+		return fc.journal.close()
+	}
 	if fc.writeIndex != -1 {
-		if bts, err := proto.Marshal(&IOMessageBatch{List: fc.writePageBuf}); err != nil {
+		if err := fc.savePage(fc.writePageBuf[:fc.writeIndex+1]); err != nil {
 			return err
-		} else {
-			if err = fc.seqDir.Save("", bytes.NewBuffer(bts)); err != nil {
-				return err
-			}
 		}
 	}
 
 	return nil
 }
 
-func OpenFileCache(path string, pageSize int) (*FileCache, error) {
-	seqDir, err := directory.OpenSequentialDirectory(path)
-	if err != nil {
-		return nil, err
-	}
-
+func OpenFileCache(path string, pageSize int, opts ...FileCacheOption) (*FileCache, error) {
 	cache := pageSize / 2
 	if cache == 0 {
 		pageSize = 20
 		cache = 10
 	}
 
-	return &FileCache{
+	fc := &FileCache{
 		path:         path,
-		seqDir:       seqDir,
 		readChan:     make(chan *IOMessage, cache),
 		writeChan:    make(chan *IOMessage, cache),
 		pageSize:     pageSize,
@@ -319,6 +791,76 @@ func OpenFileCache(path string, pageSize int) (*FileCache, error) {
 		writePageBuf: make([]*IOMessage, pageSize),
 		readIndex:    -1,
 		writeIndex:   -1,
+		writePause:   make(chan struct{}),
+		writeResume:  make(chan struct{}),
+		spaceFreed:   make(chan struct{}, 1),
+		consumers:    make(map[string]*FileCacheConsumer),
+		dataReady:    make(chan struct{}),
+		gate:         newDrainGate(),
 		closer:       make(chan struct{}),
-	}, nil
+	}
+	for _, opt := range opts {
+		opt(fc)
+	}
+
+	var err error
+	if fc.seqDir, err = directory.OpenSequentialDirectory(path, directory.SeqDirWithQuota(fc.maxBytes, fc.maxPages)); err != nil {
+		return nil, err
+	}
+	if err = fc.loadConsumers(); err != nil {
+		return nil, err
+	}
+
+	if fc.journalPolicy != nil {
+		if err = fc.replayJournal(); err != nil {
+			return nil, err
+		}
+	}
+
+	return fc, nil
+}
+
+// replayJournal restores writePageBuf from journal left by last run.
+func (fc *FileCache) replayJournal() error {
+	jn, err := openJournal(fmt.Sprintf("%s/%s", fc.path, _journalFileName), *fc.journalPolicy)
+	if err != nil {
+		return err
+	}
+	list, err := jn.replay()
+	if err != nil {
+		jn.f.Close()
+
+		return err
+	}
+
+	// pages filled up while replaying are saved into directory and journal keeps the rest only
+	for _, msg := range list {
+		if err = fc.writeRoutine(msg); err != nil {
+			jn.f.Close()
+
+			return err
+		}
+	}
+	if len(list) >= fc.pageSize {
+		if err = jn.reset(); err != nil {
+			jn.f.Close()
+
+			return err
+		}
+		for _, msg := range fc.writePageBuf[:fc.writeIndex+1] {
+			if err = jn.append(msg); err != nil {
+				jn.f.Close()
+
+				return err
+			}
+		}
+		if err = jn.sync(); err != nil {
+			jn.f.Close()
+
+			return err
+		}
+	}
+	fc.journal = jn
+
+	return nil
 }
@@ -20,10 +20,16 @@ package io
 import (
 	"context"
 	"crypto/rand"
+	"errors"
 	"fmt"
 	"log"
+	"os"
+	"strconv"
+	"strings"
 	"testing"
 	"time"
+
+	"github.com/CodapeWild/devkit/message"
 )
 
 func mockIOMessage(d time.Duration, l, c int, out chan *IOMessage) {
@@ -79,3 +85,348 @@ func TestFileCachePublish(t *testing.T) {
 		}
 	})
 }
+
+func TestFileCacheJournalReplay(t *testing.T) {
+	path := t.TempDir()
+	fc, err := OpenFileCache(path, 10, FileCacheWithJournal(JournalSyncAlways()))
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	// write messages without closing the cache to act as a crash
+	n := 15
+	for i := 0; i < n; i++ {
+		if err = fc.writeRoutine(NewIOMessage(IOMessageWithPayload([]byte(strconv.Itoa(i))))); err != nil {
+			t.Fatal(err.Error())
+		}
+	}
+
+	replayed, err := OpenFileCache(path, 10, FileCacheWithJournal(JournalSyncAlways()))
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if replayed.writeIndex != n%10-1 {
+		t.Fatalf("expect %d messages replayed got %d", n%10, replayed.writeIndex+1)
+	}
+	for i, msg := range replayed.writePageBuf[:replayed.writeIndex+1] {
+		if string(msg.Payload) != strconv.Itoa(10+i) {
+			t.Fatalf("expect message %d got %s", 10+i, msg.Payload)
+		}
+	}
+}
+
+func TestFileCacheAckRedelivery(t *testing.T) {
+	path := t.TempDir()
+	fc, err := OpenFileCache(path, 4, FileCacheWithAck(50*time.Millisecond))
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if err = fc.Start(context.TODO()); err != nil {
+		t.Fatal(err.Error())
+	}
+	for i := 0; i < 6; i++ {
+		if resp := fc.Publish(context.TODO(), NewIOMessage(IOMessageWithPayload([]byte(strconv.Itoa(i))))); !resp.IS(InputSuccess) {
+			t.Fatal(resp.Message)
+		}
+	}
+	time.Sleep(50 * time.Millisecond)
+
+	msg, resp := fc.Fetch(context.TODO())
+	if !resp.IS(OutputSuccess) {
+		t.Fatal(resp.Message)
+	}
+	if err = fc.Ack(msg); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	// the rest are fetched but never acked
+	batch, resp := fc.FetchBatch(context.TODO())
+	if !resp.IS(OutputSuccess) {
+		t.Fatal(resp.Message)
+	}
+	if l := batch.Length(); l != 5 {
+		t.Fatalf("expect 5 messages got %d", l)
+	}
+	time.Sleep(100 * time.Millisecond)
+
+	if batch, resp = fc.FetchBatch(context.TODO()); !resp.IS(OutputSuccess) {
+		t.Fatal(resp.Message)
+	}
+	if l := batch.Length(); l != 5 {
+		t.Fatalf("expect 5 messages redelivered got %d", l)
+	}
+	if err = fc.AckBatch(batch); err != nil {
+		t.Fatal(err.Error())
+	}
+	if _, resp = fc.Fetch(context.TODO()); !resp.IS(OutputEmpty) {
+		t.Fatalf("expect empty cache got %s", resp.Message)
+	}
+	fc.Close()
+
+	entries, err := os.ReadDir(path)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	for _, entry := range entries {
+		if strings.HasPrefix(entry.Name(), ".") {
+			t.Fatalf("page %s not removed after acked", entry.Name())
+		}
+	}
+}
+
+func TestFileCacheExpireLeasesOrder(t *testing.T) {
+	fc, err := OpenFileCache(t.TempDir(), 4, FileCacheWithAck(20*time.Millisecond))
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer fc.Close()
+
+	if err = fc.Start(context.TODO()); err != nil {
+		t.Fatal(err.Error())
+	}
+	for i := 0; i < 20; i++ {
+		if resp := fc.Publish(context.TODO(), NewIOMessage(IOMessageWithPayload([]byte(strconv.Itoa(i))))); !resp.IS(InputSuccess) {
+			t.Fatal(resp.Message)
+		}
+	}
+	time.Sleep(50 * time.Millisecond)
+
+	// fetched one by one but never acked
+	for i := 0; i < 20; i++ {
+		if _, resp := fc.Fetch(context.TODO()); !resp.IS(OutputSuccess) {
+			t.Fatal(resp.Message)
+		}
+	}
+	time.Sleep(40 * time.Millisecond)
+
+	for i := 0; i < 20; i++ {
+		msg, resp := fc.Fetch(context.TODO())
+		if !resp.IS(OutputSuccess) {
+			t.Fatal(resp.Message)
+		}
+		if p := string(msg.(*IOMessage).Payload); p != strconv.Itoa(i) {
+			t.Fatalf("expect message %d redelivered in order got %s", i, p)
+		}
+	}
+}
+
+func TestFileCacheQuotaDropOldest(t *testing.T) {
+	fc, err := OpenFileCache(t.TempDir(), 2, FileCacheWithQuota(0, 2, EvictDropOldest))
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	for i := 0; i < 8; i++ {
+		if err = fc.writeRoutine(NewIOMessage(IOMessageWithPayload([]byte(strconv.Itoa(i))))); err != nil {
+			t.Fatal(err.Error())
+		}
+	}
+	if err = fc.Start(context.TODO()); err != nil {
+		t.Fatal(err.Error())
+	}
+	defer fc.Close()
+
+	if resp := fc.Publish(context.TODO(), NewIOMessage()); !resp.IS(InputEvicted) {
+		t.Fatalf("expect eviction reported got %s", resp.Message)
+	}
+	for i := 4; i < 8; i++ {
+		msg, resp := fc.Fetch(context.TODO())
+		if !resp.IS(OutputSuccess) {
+			t.Fatal(resp.Message)
+		}
+		if p := string(msg.(*IOMessage).Payload); p != strconv.Itoa(i) {
+			t.Fatalf("expect message %d got %s", i, p)
+		}
+	}
+}
+
+func TestFileCacheConsumerGroups(t *testing.T) {
+	path := t.TempDir()
+	fc, err := OpenFileCache(path, 2)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	metrics, err := fc.Consumer("metrics")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	archive, err := fc.Consumer("archive")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	for i := 0; i < 6; i++ {
+		if err = fc.writeRoutine(NewIOMessage(IOMessageWithPayload([]byte(strconv.Itoa(i))))); err != nil {
+			t.Fatal(err.Error())
+		}
+	}
+
+	fetch := func(cc *FileCacheConsumer, from, to int) {
+		for i := from; i < to; i++ {
+			msg, resp := cc.Fetch(context.TODO())
+			if !resp.IS(OutputSuccess) {
+				t.Fatal(resp.Message)
+			}
+			if p := string(msg.(*IOMessage).Payload); p != strconv.Itoa(i) {
+				t.Fatalf("%s expect message %d got %s", cc.name, i, p)
+			}
+		}
+	}
+	fetch(metrics, 0, 6)
+	fetch(archive, 0, 3)
+	if _, n := fc.seqDir.Usage(); n != 2 {
+		t.Fatalf("expect 2 pages kept for archive got %d", n)
+	}
+
+	// cursors survive reopening
+	reopened, err := OpenFileCache(path, 2)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if archive, err = reopened.Consumer("archive"); err != nil {
+		t.Fatal(err.Error())
+	}
+	fetch(archive, 3, 6)
+}
+
+func TestFileCacheConsumerName(t *testing.T) {
+	path := t.TempDir()
+	fc, err := OpenFileCache(path, 2)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	for _, name := range []string{"", ".x", "a/b", `a\b`} {
+		if _, err = fc.Consumer(name); !errors.Is(err, ErrInvalidConsumer) {
+			t.Fatalf("expect %q rejected got %v", name, err)
+		}
+	}
+	if _, err = fc.Consumer("x.y"); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	reopened, err := OpenFileCache(path, 2)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if len(reopened.consumers) != 1 || reopened.consumers["x.y"] == nil {
+		t.Fatalf("expect consumer x.y restored got %v", reopened.consumers)
+	}
+}
+
+func TestFileCacheBlockingFetch(t *testing.T) {
+	fc, err := OpenFileCache(t.TempDir(), 10, FileCacheWithBlockingFetch())
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if err = fc.Start(context.TODO()); err != nil {
+		t.Fatal(err.Error())
+	}
+	defer fc.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, resp := fc.Fetch(ctx); !resp.IS(OutputTimeout) {
+		t.Fatalf("expect timeout on empty cache got %s", resp.Message)
+	}
+
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		fc.Publish(context.TODO(), NewIOMessage(IOMessageWithPayload([]byte("wake up"))))
+	}()
+	ctx, cancel = context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	msg, resp := fc.Fetch(ctx)
+	if !resp.IS(OutputSuccess) {
+		t.Fatal(resp.Message)
+	}
+	if p := string(msg.(*IOMessage).Payload); p != "wake up" {
+		t.Fatalf("unexpected message %s", p)
+	}
+}
+
+func TestFileCacheSubscribeStream(t *testing.T) {
+	var (
+		n        = 15
+		received = make(chan message.Message, n)
+	)
+	fc, err := OpenFileCache(t.TempDir(), 4)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	fc.SubscribeStream(func(ctx context.Context, stream chan message.Message, out chan *IOResponse) {
+		for msg := range stream {
+			received <- msg
+			out <- OutputSuccess
+		}
+	})
+	if err = fc.Start(context.TODO()); err != nil {
+		t.Fatal(err.Error())
+	}
+	defer fc.Close()
+
+	stream := make(chan message.Message)
+	go func() {
+		for i := 0; i < n; i++ {
+			stream <- NewIOMessage(IOMessageWithPayload([]byte(strconv.Itoa(i))))
+		}
+		close(stream)
+	}()
+	if resp := fc.PublishStream(context.TODO(), stream); !resp.IS(InputSuccess) {
+		t.Fatal(resp.Message)
+	}
+
+	for i := 0; i < n; i++ {
+		select {
+		case msg := <-received:
+			if p := string(msg.(*IOMessage).Payload); p != strconv.Itoa(i) {
+				t.Fatalf("expect message %d got %s", i, p)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("message %d not delivered", i)
+		}
+	}
+}
+
+func TestFileCacheShutdown(t *testing.T) {
+	path := t.TempDir()
+	fc, err := OpenFileCache(path, 4)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if err = fc.Start(context.TODO()); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	n := 10
+	for i := 0; i < n; i++ {
+		if resp := fc.Publish(context.TODO(), NewIOMessage(IOMessageWithPayload([]byte(strconv.Itoa(i))))); !resp.IS(InputSuccess) {
+			t.Fatal(resp.Message)
+		}
+	}
+	ctx, cancel := context.WithTimeout(context.TODO(), time.Second)
+	defer cancel()
+	if err = fc.Shutdown(ctx); err != nil {
+		t.Fatal(err.Error())
+	}
+	if resp := fc.Publish(context.TODO(), NewIOMessage()); !resp.IS(IOClosed) {
+		t.Fatalf("expect closed got %s", resp.Message)
+	}
+
+	// all the messages published are persisted in order
+	reopened, err := OpenFileCache(path, 4)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if err = reopened.Start(context.TODO()); err != nil {
+		t.Fatal(err.Error())
+	}
+	defer reopened.Close()
+
+	for i := 0; i < n; i++ {
+		msg, resp := reopened.Fetch(context.TODO())
+		if !resp.IS(OutputSuccess) {
+			t.Fatal(resp.Message)
+		}
+		if payload := string(msg.(*IOMessage).Payload); payload != strconv.Itoa(i) {
+			t.Fatalf("expect message %d got %s", i, payload)
+		}
+	}
+}
@@ -19,32 +19,440 @@ package io
 
 import (
 	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"sync"
 
+	"github.com/CodapeWild/devkit/directory"
 	"github.com/CodapeWild/devkit/id"
 	"github.com/CodapeWild/devkit/message"
 )
 
 var _ PubBatchAndFetchBatch = (*FileChain)(nil)
 
+var ErrBrokenRecord = errors.New("broken page record")
+
 const (
 	_headFileName = "head"
 	_tailFileName = "tail"
 )
 
+/*
+	Record layout in page file, every record is an encoded IOMessageBatch
+
+| len            | crc                  | payload                |
+| -------------- | -------------------- | ---------------------- |
+| payload length | crc32 of the payload | encoded IOMessageBatch |
+| uint32         | uint32               | []byte                 |
+*/
+const _recordHeaderLen = 4 + 4
+
+// FileChain is an append-only log of message batches chained over page files under path,
+// batches are appended to the tail page and read from the head page, both positions are
+// persisted in the head and tail files so they survive restarts.
 type FileChain struct {
-	path     string
-	pageSize int
-	idflk    *id.IDFlaker
+	sync.Mutex
+	path       string
+	pageSize   int // max number of messages in one page
+	idflk      *id.IDFlaker
+	headPage   string   // name of the page reading from
+	headOffset int64    // offset of the next unread record in head page
+	headFile   *os.File // opened for reading
+	tailPage   string   // name of the page appending to
+	tailCount  int      // number of messages already in tail page
+	tailFile   *os.File // opened for appending
+	closer     chan struct{}
 }
 
 func (fc *FileChain) PublishBatch(ctx context.Context, batch message.MessageList) *IOResponse {
-	return nil
+	if err := ctx.Err(); err != nil {
+		return InputFailed.With(IORespWithMessage(err.Error()))
+	}
+	iomsgbatch, ok := batch.(*IOMessageBatch)
+	if !ok {
+		return IOWrongMsgType
+	}
+
+	bts, err := iomsgbatch.Encode()
+	if err != nil {
+		return InputFailed.With(IORespWithMessage(err.Error()))
+	}
+
+	fc.Lock()
+	defer fc.Unlock()
+
+	select {
+	case <-fc.closer:
+		return IOClosed
+	default:
+	}
+
+	// a batch larger than pageSize takes a page on its own
+	if fc.tailCount != 0 && fc.tailCount+iomsgbatch.Length() > fc.pageSize {
+		if err = fc.rollTail(); err != nil {
+			return InputFailed.With(IORespWithMessage(err.Error()))
+		}
+	}
+	if err = writeRecord(fc.tailFile, bts); err != nil {
+		return InputFailed.With(IORespWithMessage(err.Error()))
+	}
+	if err = fc.tailFile.Sync(); err != nil {
+		return InputFailed.With(IORespWithMessage(err.Error()))
+	}
+	fc.tailCount += iomsgbatch.Length()
+
+	return InputSuccess
 }
 
+// FetchBatch returns the batches in the order they were published, one batch per call.
 func (fc *FileChain) FetchBatch(ctx context.Context) (message.MessageList, *IOResponse) {
-	return nil, nil
+	if err := ctx.Err(); err != nil {
+		return nil, InputFailed.With(IORespWithMessage(err.Error()))
+	}
+
+	fc.Lock()
+	defer fc.Unlock()
+
+	select {
+	case <-fc.closer:
+		return nil, IOClosed
+	default:
+	}
+
+	for {
+		bts, n, err := readRecord(fc.headFile, fc.headOffset)
+		if err != nil {
+			return nil, OutputFailed.With(IORespWithMessage(err.Error()))
+		}
+		if n != 0 {
+			batch := &IOMessageBatch{}
+			if err = batch.Decode(bts); err != nil {
+				return nil, OutputFailed.With(IORespWithMessage(err.Error()))
+			}
+			fc.headOffset += n
+			if err = fc.saveHead(); err != nil {
+				return nil, OutputFailed.With(IORespWithMessage(err.Error()))
+			}
+
+			return batch, OutputSuccess
+		}
+
+		// head page is drained
+		if fc.headPage == fc.tailPage {
+			return nil, OutputEmpty
+		}
+		if err = fc.forwardHead(); err != nil {
+			return nil, OutputFailed.With(IORespWithMessage(err.Error()))
+		}
+	}
+}
+
+func (fc *FileChain) Close() {
+	fc.Lock()
+	defer fc.Unlock()
+
+	select {
+	case <-fc.closer:
+	default:
+		close(fc.closer)
+		if err := fc.headFile.Close(); err != nil {
+			log.Println(err.Error())
+		}
+		if err := fc.tailFile.Close(); err != nil {
+			log.Println(err.Error())
+		}
+	}
+}
+
+// rollTail starts a new tail page, the tail file is saved before the page is created
+// so a crash in between is repaired in OpenFileChain.
+func (fc *FileChain) rollTail() error {
+	name := "." + fc.idflk.NextID().String('-')
+	if err := saveState(fc.path, _tailFileName, name); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(fc.formatPath(name), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	if err = fc.tailFile.Close(); err != nil {
+		log.Println(err.Error())
+	}
+	fc.tailPage = name
+	fc.tailCount = 0
+	fc.tailFile = f
+
+	return nil
+}
+
+// forwardHead moves head onto the page next to it and deletes the drained one.
+func (fc *FileChain) forwardHead() error {
+	pages, err := fc.listPages()
+	if err != nil {
+		return err
+	}
+	i := 0
+	for i < len(pages) && comparePages(pages[i], fc.headPage) <= 0 {
+		i++
+	}
+	if i == len(pages) {
+		return fmt.Errorf("page next to %s not found", fc.headPage)
+	}
+
+	f, err := os.Open(fc.formatPath(pages[i]))
+	if err != nil {
+		return err
+	}
+	drained := fc.headPage
+	if err = fc.headFile.Close(); err != nil {
+		log.Println(err.Error())
+	}
+	fc.headPage = pages[i]
+	fc.headOffset = 0
+	fc.headFile = f
+	if err = fc.saveHead(); err != nil {
+		return err
+	}
+
+	return os.Remove(fc.formatPath(drained))
+}
+
+func (fc *FileChain) saveHead() error {
+	return saveState(fc.path, _headFileName, fmt.Sprintf("%s %d", fc.headPage, fc.headOffset))
+}
+
+func (fc *FileChain) listPages() ([]string, error) {
+	return listPages(fc.path)
+}
+
+func (fc *FileChain) formatPath(page string) string {
+	return fmt.Sprintf("%s/%s", fc.path, page)
+}
+
+// listPages returns names of the page files under path sorted from the oldest to the newest.
+func listPages(path string) ([]string, error) {
+	entries, err := os.ReadDir(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var pages []string
+	for _, entry := range entries {
+		if name := entry.Name(); strings.HasPrefix(name, ".") && !entry.IsDir() {
+			if _, err = id.FromString(strings.TrimPrefix(name, "."), '-'); err == nil {
+				pages = append(pages, name)
+			}
+		}
+	}
+	sort.Slice(pages, func(i, j int) bool { return comparePages(pages[i], pages[j]) < 0 })
+
+	return pages, nil
+}
+
+// recoverTail drops the torn record left at the end of tail page by a crash
+// and counts the messages in the page.
+func (fc *FileChain) recoverTail() error {
+	f, err := os.OpenFile(fc.formatPath(fc.tailPage), os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	var offset int64
+	for {
+		bts, n, err := readRecord(f, offset)
+		if err != nil {
+			if !errors.Is(err, ErrBrokenRecord) {
+				return err
+			}
+			log.Printf("truncate broken tail page %s at %d", fc.tailPage, offset)
+
+			return f.Truncate(offset)
+		}
+		if n == 0 {
+			return nil
+		}
+
+		batch := &IOMessageBatch{}
+		if err = batch.Decode(bts); err != nil {
+			log.Printf("truncate broken tail page %s at %d", fc.tailPage, offset)
+
+			return f.Truncate(offset)
+		}
+		fc.tailCount += batch.Length()
+		offset += n
+	}
+}
+
+// writeRecord appends one record, flushing it onto disk is left to caller.
+func writeRecord(f *os.File, payload []byte) error {
+	buf := make([]byte, _recordHeaderLen+len(payload))
+	binary.BigEndian.PutUint32(buf, uint32(len(payload)))
+	binary.BigEndian.PutUint32(buf[4:], crc32.ChecksumIEEE(payload))
+	copy(buf[_recordHeaderLen:], payload)
+	_, err := f.Write(buf)
+
+	return err
+}
+
+// readRecord reads the record at offset, it returns zero length when offset reaches the end of file.
+func readRecord(f *os.File, offset int64) ([]byte, int64, error) {
+	fi, err := f.Stat()
+	if err != nil {
+		return nil, 0, err
+	}
+	if offset >= fi.Size() {
+		return nil, 0, nil
+	}
+	if fi.Size()-offset < _recordHeaderLen {
+		return nil, 0, ErrBrokenRecord
+	}
+
+	header := make([]byte, _recordHeaderLen)
+	if _, err = f.ReadAt(header, offset); err != nil {
+		return nil, 0, err
+	}
+	l := int64(binary.BigEndian.Uint32(header))
+	if fi.Size()-offset-_recordHeaderLen < l {
+		return nil, 0, ErrBrokenRecord
+	}
+	payload := make([]byte, l)
+	if _, err = f.ReadAt(payload, offset+_recordHeaderLen); err != nil {
+		return nil, 0, err
+	}
+	if crc32.ChecksumIEEE(payload) != binary.BigEndian.Uint32(header[4:]) {
+		return nil, 0, ErrBrokenRecord
+	}
+
+	return payload, _recordHeaderLen + l, nil
+}
+
+// saveState replaces state file atomically by renaming a temporary file over it.
+func saveState(path, name, state string) error {
+	tmp := fmt.Sprintf("%s/%s.tmp", path, name)
+	if err := os.WriteFile(tmp, []byte(state), 0644); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, fmt.Sprintf("%s/%s", path, name))
+}
+
+func loadState(path, name string) (string, error) {
+	bts, err := os.ReadFile(fmt.Sprintf("%s/%s", path, name))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return "", nil
+		}
+
+		return "", err
+	}
+
+	return strings.TrimSpace(string(bts)), nil
+}
+
+func comparePages(a, b string) int {
+	ida, erra := id.FromString(strings.TrimPrefix(a, "."), '-')
+	idb, errb := id.FromString(strings.TrimPrefix(b, "."), '-')
+	if erra != nil || errb != nil {
+		return strings.Compare(a, b)
+	}
+
+	ahigh, alow := ida.Int64()
+	bhigh, blow := idb.Int64()
+	switch {
+	case ahigh < bhigh || (ahigh == bhigh && alow < blow):
+		return -1
+	case ahigh == bhigh && alow == blow:
+		return 0
+	default:
+		return 1
+	}
 }
 
 func OpenFileChain(path string, pageSize int) (*FileChain, error) {
-	return nil, nil
+	if err := directory.Exist(path); err != nil {
+		if !errors.Is(err, directory.ErrNotDir) {
+			if err = os.MkdirAll(path, 0755); err != nil {
+				return nil, err
+			}
+		} else {
+			return nil, err
+		}
+	}
+	if pageSize <= 0 {
+		pageSize = 20
+	}
+
+	fc := &FileChain{
+		path:     path,
+		pageSize: pageSize,
+		idflk:    id.NewIDFlaker(),
+		closer:   make(chan struct{}),
+	}
+	pages, err := fc.listPages()
+	if err != nil {
+		return nil, err
+	}
+
+	// the tail page named in tail file may be missing if crashed while rolling
+	if fc.tailPage, err = loadState(path, _tailFileName); err != nil {
+		return nil, err
+	}
+	if fc.tailPage == "" {
+		if len(pages) != 0 {
+			fc.tailPage = pages[len(pages)-1]
+		} else {
+			fc.tailPage = "." + fc.idflk.NextID().String('-')
+		}
+		if err = saveState(path, _tailFileName, fc.tailPage); err != nil {
+			return nil, err
+		}
+	}
+	if err = fc.recoverTail(); err != nil {
+		return nil, err
+	}
+
+	state, err := loadState(path, _headFileName)
+	if err != nil {
+		return nil, err
+	}
+	if state != "" {
+		if _, err = fmt.Sscanf(state, "%s %d", &fc.headPage, &fc.headOffset); err != nil {
+			return nil, err
+		}
+		if _, err = os.Stat(fc.formatPath(fc.headPage)); err != nil {
+			fc.headPage = ""
+		}
+	}
+	if fc.headPage == "" {
+		if pages, err = fc.listPages(); err != nil {
+			return nil, err
+		}
+		fc.headPage, fc.headOffset = pages[0], 0
+	}
+	// clean up the pages drained but not deleted before last exit
+	for _, page := range pages {
+		if comparePages(page, fc.headPage) < 0 {
+			if err = os.Remove(fc.formatPath(page)); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if fc.headFile, err = os.Open(fc.formatPath(fc.headPage)); err != nil {
+		return nil, err
+	}
+	if fc.tailFile, err = os.OpenFile(fc.formatPath(fc.tailPage), os.O_WRONLY|os.O_APPEND, 0644); err != nil {
+		fc.headFile.Close()
+
+		return nil, err
+	}
+
+	return fc, nil
 }
@@ -0,0 +1,83 @@
+/*
+ *   Copyright (c) 2023 CodapeWild
+ *   All rights reserved.
+
+ *   Licensed under the Apache License, Version 2.0 (the "License");
+ *   you may not use this file except in compliance with the License.
+ *   You may obtain a copy of the License at
+
+ *   http://www.apache.org/licenses/LICENSE-2.0
+
+ *   Unless required by applicable law or agreed to in writing, software
+ *   distributed under the License is distributed on an "AS IS" BASIS,
+ *   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *   See the License for the specific language governing permissions and
+ *   limitations under the License.
+ */
+
+package io
+
+import (
+	"context"
+	"strconv"
+	"testing"
+)
+
+func mockIOMessageBatch(start, c int) *IOMessageBatch {
+	batch := &IOMessageBatch{}
+	for i := start; i < start+c; i++ {
+		batch.List = append(batch.List, NewIOMessage(IOMessageWithCoding("text"), IOMessageWithPayload([]byte(strconv.Itoa(i)))))
+	}
+
+	return batch
+}
+
+func TestFileChainPublishAndFetch(t *testing.T) {
+	path := t.TempDir()
+	fc, err := OpenFileChain(path, 10)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	var (
+		batches   = 10
+		batchSize = 4
+	)
+	for i := 0; i < batches; i++ {
+		if resp := fc.PublishBatch(context.TODO(), mockIOMessageBatch(i*batchSize, batchSize)); !resp.IS(InputSuccess) {
+			t.Fatal(resp.Message)
+		}
+	}
+
+	// read half of the chain then reopen it
+	var next int
+	fetch := func(fc *FileChain, n int) {
+		for i := 0; i < n; i++ {
+			batch, resp := fc.FetchBatch(context.TODO())
+			if !resp.IS(OutputSuccess) {
+				t.Fatal(resp.Message)
+			}
+			for _, msg := range batch.(*IOMessageBatch).List {
+				if string(msg.Payload) != strconv.Itoa(next) {
+					t.Fatalf("expect message %d got %s", next, msg.Payload)
+				}
+				next++
+			}
+		}
+	}
+	fetch(fc, batches/2)
+	fc.Close()
+
+	if fc, err = OpenFileChain(path, 10); err != nil {
+		t.Fatal(err.Error())
+	}
+	defer fc.Close()
+
+	fetch(fc, batches-batches/2)
+	if _, resp := fc.FetchBatch(context.TODO()); !resp.IS(OutputEmpty) {
+		t.Fatalf("expect empty chain got %s", resp.Message)
+	}
+	if next != batches*batchSize {
+		t.Fatalf("expect %d messages got %d", batches*batchSize, next)
+	}
+}
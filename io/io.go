@@ -79,6 +79,14 @@ type FetchMessageBatch interface {
 	FetchBatch(ctx context.Context) (message.MessageList, *IOResponse)
 }
 
+type AckMessage interface {
+	Ack(msg message.Message) error
+}
+
+type AckMessageBatch interface {
+	AckBatch(batch message.MessageList) error
+}
+
 type PubAndSub interface {
 	PublishMessage
 	SubscribeMessage
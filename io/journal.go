@@ -0,0 +1,143 @@
+/*
+ *   Copyright (c) 2023 CodapeWild
+ *   All rights reserved.
+
+ *   Licensed under the Apache License, Version 2.0 (the "License");
+ *   you may not use this file except in compliance with the License.
+ *   You may obtain a copy of the License at
+
+ *   http://www.apache.org/licenses/LICENSE-2.0
+
+ *   Unless required by applicable law or agreed to in writing, software
+ *   distributed under the License is distributed on an "AS IS" BASIS,
+ *   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *   See the License for the specific language governing permissions and
+ *   limitations under the License.
+ */
+
+package io
+
+import (
+	"errors"
+	"log"
+	"os"
+	"time"
+)
+
+const _journalFileName = "journal"
+
+// JournalSyncPolicy decides when the journal is flushed onto disk by fsync.
+type JournalSyncPolicy struct {
+	every    int           // fsync after every n messages appended
+	interval time.Duration // fsync periodically
+}
+
+// JournalSyncAlways fsyncs journal on every message appended.
+func JournalSyncAlways() JournalSyncPolicy {
+	return JournalSyncPolicy{every: 1}
+}
+
+// JournalSyncEvery fsyncs journal once n messages appended.
+func JournalSyncEvery(n int) JournalSyncPolicy {
+	if n <= 0 {
+		n = 1
+	}
+
+	return JournalSyncPolicy{every: n}
+}
+
+// JournalSyncInterval fsyncs journal every d duration.
+func JournalSyncInterval(d time.Duration) JournalSyncPolicy {
+	return JournalSyncPolicy{interval: d}
+}
+
+// journal is an append-only write-ahead log of messages not paged out to disk yet,
+// it shares the record format with FileChain pages.
+type journal struct {
+	f        *os.File
+	policy   JournalSyncPolicy
+	unsynced int // number of messages appended since last fsync
+}
+
+func (jn *journal) append(msg *IOMessage) error {
+	bts, err := msg.Encode()
+	if err != nil {
+		return err
+	}
+	if err = writeRecord(jn.f, bts); err != nil {
+		return err
+	}
+
+	if jn.unsynced++; jn.policy.every > 0 && jn.unsynced >= jn.policy.every {
+		return jn.sync()
+	}
+
+	return nil
+}
+
+func (jn *journal) sync() error {
+	if jn.unsynced == 0 {
+		return nil
+	}
+	jn.unsynced = 0
+
+	return jn.f.Sync()
+}
+
+// reset drops all the records once the messages are persisted elsewhere.
+func (jn *journal) reset() error {
+	jn.unsynced = 0
+	if err := jn.f.Truncate(0); err != nil {
+		return err
+	}
+
+	return jn.f.Sync()
+}
+
+// replay returns messages recorded in journal, the torn record left by crash is truncated.
+func (jn *journal) replay() ([]*IOMessage, error) {
+	var (
+		list   []*IOMessage
+		offset int64
+	)
+	for {
+		bts, n, err := readRecord(jn.f, offset)
+		if err != nil {
+			if !errors.Is(err, ErrBrokenRecord) {
+				return nil, err
+			}
+			log.Printf("truncate broken journal at %d", offset)
+
+			return list, jn.f.Truncate(offset)
+		}
+		if n == 0 {
+			return list, nil
+		}
+
+		msg := &IOMessage{}
+		if err = msg.Decode(bts); err != nil {
+			log.Printf("truncate broken journal at %d", offset)
+
+			return list, jn.f.Truncate(offset)
+		}
+		list = append(list, msg)
+		offset += n
+	}
+}
+
+func (jn *journal) close() error {
+	if err := jn.sync(); err != nil {
+		return err
+	}
+
+	return jn.f.Close()
+}
+
+func openJournal(path string, policy JournalSyncPolicy) (*journal, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	return &journal{f: f, policy: policy}, nil
+}
@@ -0,0 +1,112 @@
+/*
+ *   Copyright (c) 2023 CodapeWild
+ *   All rights reserved.
+
+ *   Licensed under the Apache License, Version 2.0 (the "License");
+ *   you may not use this file except in compliance with the License.
+ *   You may obtain a copy of the License at
+
+ *   http://www.apache.org/licenses/LICENSE-2.0
+
+ *   Unless required by applicable law or agreed to in writing, software
+ *   distributed under the License is distributed on an "AS IS" BASIS,
+ *   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *   See the License for the specific language governing permissions and
+ *   limitations under the License.
+ */
+
+package io
+
+import (
+	"sort"
+	"time"
+
+	"github.com/CodapeWild/devkit/message"
+)
+
+var (
+	_ AckMessage      = (*FileCache)(nil)
+	_ AckMessageBatch = (*FileCache)(nil)
+)
+
+// pageLease counts the messages of a page file not acked yet, the file is removed once all acked.
+type pageLease struct {
+	name    string
+	pending int
+}
+
+type leasedMessage struct {
+	msg  *IOMessage
+	page *pageLease
+}
+
+type lease struct {
+	seq      uint64 // order of fetching, redelivery keeps it
+	list     []*leasedMessage
+	deadline time.Time
+}
+
+// Ack releases the message returned by Fetch, it is a no-op if ack mode is off.
+func (fc *FileCache) Ack(msg message.Message) error {
+	fc.Lock()
+	defer fc.Unlock()
+
+	return fc.release(msg)
+}
+
+// AckBatch releases the batch returned by FetchBatch, it is a no-op if ack mode is off.
+func (fc *FileCache) AckBatch(batch message.MessageList) error {
+	fc.Lock()
+	defer fc.Unlock()
+
+	return fc.release(batch)
+}
+
+func (fc *FileCache) lease(key any, list []*leasedMessage) {
+	fc.leaseSeq++
+	fc.leases[key] = &lease{seq: fc.leaseSeq, list: list, deadline: time.Now().Add(fc.ackTimeout)}
+}
+
+func (fc *FileCache) release(key any) error {
+	if fc.ackTimeout == 0 {
+		return nil
+	}
+
+	l, ok := fc.leases[key]
+	if !ok {
+		return ErrLeaseNotFound
+	}
+	delete(fc.leases, key)
+
+	for _, leased := range l.list {
+		if leased.page == nil {
+			continue
+		}
+		if leased.page.pending--; leased.page.pending == 0 {
+			if err := fc.seqDir.Remove(leased.page.name); err != nil {
+				return err
+			}
+			fc.notifySpaceFreed()
+		}
+	}
+
+	return nil
+}
+
+// expireLeases moves the messages not acked in time into redelivery queue in the order fetched.
+func (fc *FileCache) expireLeases() {
+	var (
+		now     = time.Now()
+		expired []*lease
+	)
+	for key, l := range fc.leases {
+		if now.After(l.deadline) {
+			delete(fc.leases, key)
+			expired = append(expired, l)
+		}
+	}
+	sort.Slice(expired, func(i, j int) bool { return expired[i].seq < expired[j].seq })
+	for _, l := range expired {
+		fc.redelivery = append(fc.redelivery, l.list...)
+	}
+}
@@ -22,8 +22,13 @@ import "errors"
 var (
 	ErrIOClosed      = errors.New("io closed")
 	ErrIOUncompleted = errors.New("io init uncompleted")
+	ErrLeaseNotFound = errors.New("lease not found or expired")
 )
 
+// IOStatus_IEvicted reports input accepted while cached data shed by quota, it is set apart
+// from the generated values so it won't collide with status added into proto later.
+const IOStatus_IEvicted IOStatus = 100
+
 var (
 	IOSuccess      = NewIOResponse(IOStatus_IOSuccess, IORespWithMessage("io success"))
 	IOClosed       = NewIOResponse(IOStatus_IOClosed, IORespWithMessage("io closed"))
@@ -33,6 +38,7 @@ var (
 	InputBusy      = NewIOResponse(IOStatus_IBusy, IORespWithMessage("input busy"))
 	InputTimeout   = NewIOResponse(IOStatus_ITimeout, IORespWithMessage("input timeout"))
 	InputFailed    = NewIOResponse(IOStatus_IFailed, IORespWithMessage("input failed"))
+	InputEvicted   = NewIOResponse(IOStatus_IEvicted, IORespWithMessage("input evicted"))
 	OutputSuccess  = NewIOResponse(IOStatus_OOK, IORespWithMessage("output success"))
 	OutputEmpty    = NewIOResponse(IOStatus_OEMPTY, IORespWithMessage("output empty"))
 	OutputBusy     = NewIOResponse(IOStatus_OBusy, IORespWithMessage("output busy"))
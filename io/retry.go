@@ -0,0 +1,89 @@
+/*
+ *   Copyright (c) 2023 CodapeWild
+ *   All rights reserved.
+
+ *   Licensed under the Apache License, Version 2.0 (the "License");
+ *   you may not use this file except in compliance with the License.
+ *   You may obtain a copy of the License at
+
+ *   http://www.apache.org/licenses/LICENSE-2.0
+
+ *   Unless required by applicable law or agreed to in writing, software
+ *   distributed under the License is distributed on an "AS IS" BASIS,
+ *   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *   See the License for the specific language governing permissions and
+ *   limitations under the License.
+ */
+
+package io
+
+import (
+	"context"
+	"math"
+	"math/rand"
+	"time"
+)
+
+// RetryPolicy retries failed deliveries with exponential backoff and jitter.
+type RetryPolicy struct {
+	maxAttempts int           // max number of deliveries including the first one
+	initial     time.Duration // backoff before the first retry
+	max         time.Duration // upper limit of backoff, no limit if 0
+}
+
+// RetryWithBackoff delivers at most maxAttempts times, the backoff starts from initial
+// and doubles on each retry up to max, a random jitter of up to half the backoff is
+// subtracted so retries do not happen in lockstep.
+func RetryWithBackoff(maxAttempts int, initial, max time.Duration) RetryPolicy {
+	if maxAttempts <= 0 {
+		maxAttempts = 1
+	}
+	if initial <= 0 {
+		initial = 100 * time.Millisecond
+	}
+
+	return RetryPolicy{maxAttempts: maxAttempts, initial: initial, max: max}
+}
+
+// backoff returns the duration to wait before the nth retry, starting from 1.
+func (rp RetryPolicy) backoff(n int) time.Duration {
+	d := rp.initial
+	for i := 1; i < n && (rp.max == 0 || d < rp.max); i++ {
+		// stop doubling before overflow when max is unlimited
+		if d > math.MaxInt64/2 {
+			break
+		}
+		d *= 2
+	}
+	if rp.max > 0 && d > rp.max {
+		d = rp.max
+	}
+	if d <= 0 {
+		return 0
+	}
+
+	return d - time.Duration(rand.Int63n(int64(d/2)+1))
+}
+
+// do calls deliver until it succeeds or attempts run out, the last response is returned.
+// Waiting for backoff is interrupted by ctx and closer.
+func (rp RetryPolicy) do(ctx context.Context, closer chan struct{}, deliver func() *IOResponse) *IOResponse {
+	resp := deliver()
+	for n := 1; n < rp.maxAttempts && resp != nil && !resp.IS(OutputSuccess); n++ {
+		timer := time.NewTimer(rp.backoff(n))
+		select {
+		case <-closer:
+			timer.Stop()
+
+			return resp
+		case <-ctx.Done():
+			timer.Stop()
+
+			return resp
+		case <-timer.C:
+		}
+		resp = deliver()
+	}
+
+	return resp
+}
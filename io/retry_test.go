@@ -0,0 +1,37 @@
+/*
+ *   Copyright (c) 2023 CodapeWild
+ *   All rights reserved.
+
+ *   Licensed under the Apache License, Version 2.0 (the "License");
+ *   you may not use this file except in compliance with the License.
+ *   You may obtain a copy of the License at
+
+ *   http://www.apache.org/licenses/LICENSE-2.0
+
+ *   Unless required by applicable law or agreed to in writing, software
+ *   distributed under the License is distributed on an "AS IS" BASIS,
+ *   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *   See the License for the specific language governing permissions and
+ *   limitations under the License.
+ */
+
+package io
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRetryBackoff(t *testing.T) {
+	rp := RetryWithBackoff(100, 100*time.Millisecond, 0)
+	for n := 1; n < 100; n++ {
+		if d := rp.backoff(n); d <= 0 {
+			t.Fatalf("expect positive backoff for retry %d got %s", n, d)
+		}
+	}
+
+	rp = RetryWithBackoff(10, 100*time.Millisecond, time.Second)
+	if d := rp.backoff(9); d < 500*time.Millisecond || d > time.Second {
+		t.Fatalf("expect backoff capped by max got %s", d)
+	}
+}
@@ -0,0 +1,77 @@
+/*
+ *   Copyright (c) 2023 CodapeWild
+ *   All rights reserved.
+
+ *   Licensed under the Apache License, Version 2.0 (the "License");
+ *   you may not use this file except in compliance with the License.
+ *   You may obtain a copy of the License at
+
+ *   http://www.apache.org/licenses/LICENSE-2.0
+
+ *   Unless required by applicable law or agreed to in writing, software
+ *   distributed under the License is distributed on an "AS IS" BASIS,
+ *   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *   See the License for the specific language governing permissions and
+ *   limitations under the License.
+ */
+
+package io
+
+import (
+	"context"
+	"sync"
+)
+
+// drainGate stops publishing before workers drain, publishers stay inside the gate
+// while sending so nothing is sent after draining closed.
+type drainGate struct {
+	mu       sync.RWMutex
+	draining chan struct{} // closed once publishing stopped, workers drain and exit then
+	workers  sync.WaitGroup
+}
+
+// enter lets publisher in unless draining, leave must be called after sending if true returned.
+func (dg *drainGate) enter() bool {
+	dg.mu.RLock()
+	select {
+	case <-dg.draining:
+		dg.mu.RUnlock()
+
+		return false
+	default:
+		return true
+	}
+}
+
+func (dg *drainGate) leave() {
+	dg.mu.RUnlock()
+}
+
+// shutdown stops publishing and waits for workers to exit, ctx error is returned
+// if ctx done first and the caller is responsible to stop workers by force.
+func (dg *drainGate) shutdown(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		dg.mu.Lock()
+		select {
+		case <-dg.draining:
+		default:
+			close(dg.draining)
+		}
+		dg.mu.Unlock()
+
+		dg.workers.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-done:
+		return nil
+	}
+}
+
+func newDrainGate() *drainGate {
+	return &drainGate{draining: make(chan struct{})}
+}
@@ -0,0 +1,53 @@
+/*
+ *   Copyright (c) 2023 CodapeWild
+ *   All rights reserved.
+
+ *   Licensed under the Apache License, Version 2.0 (the "License");
+ *   you may not use this file except in compliance with the License.
+ *   You may obtain a copy of the License at
+
+ *   http://www.apache.org/licenses/LICENSE-2.0
+
+ *   Unless required by applicable law or agreed to in writing, software
+ *   distributed under the License is distributed on an "AS IS" BASIS,
+ *   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *   See the License for the specific language governing permissions and
+ *   limitations under the License.
+ */
+
+package io
+
+import (
+	"context"
+
+	"github.com/CodapeWild/devkit/message"
+)
+
+// publishStream publishes messages received from stream one by one until stream closed,
+// publishing stops at the first failed response, InputEvicted is kept and returned at last.
+func publishStream(ctx context.Context, stream chan message.Message, closer chan struct{}, publish func(context.Context, message.Message) *IOResponse) *IOResponse {
+	final := InputSuccess
+	for {
+		select {
+		case <-ctx.Done():
+			if _, ok := ctx.Deadline(); ok {
+				return InputTimeout
+			} else {
+				return InputFailed.With(IORespWithMessage(ctx.Err().Error()))
+			}
+		case <-closer:
+			return IOClosed
+		case msg, ok := <-stream:
+			if !ok {
+				return final
+			}
+
+			resp := publish(ctx, msg)
+			if resp.IS(InputEvicted) {
+				final = resp
+			} else if !resp.IS(InputSuccess) {
+				return resp
+			}
+		}
+	}
+}
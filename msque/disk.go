@@ -0,0 +1,862 @@
+/*
+ *   Copyright (c) 2023 CodapeWild
+ *   All rights reserved.
+
+ *   Licensed under the Apache License, Version 2.0 (the "License");
+ *   you may not use this file except in compliance with the License.
+ *   You may obtain a copy of the License at
+
+ *   http://www.apache.org/licenses/LICENSE-2.0
+
+ *   Unless required by applicable law or agreed to in writing, software
+ *   distributed under the License is distributed on an "AS IS" BASIS,
+ *   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *   See the License for the specific language governing permissions and
+ *   limitations under the License.
+ */
+
+package msque
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var _ MessageQueue = (*DiskQueue)(nil)
+
+var ErrInvalidSubscriber = errors.New("invalid subscriber name")
+
+const (
+	_segmentSuffix = ".seg"
+	_segmentV1     = ".v1" // segment of frames with ttl, unversioned ones are legacy
+	_offsetSuffix  = ".offset"
+)
+
+// DiskQueue is a persistent broker appending message frames to the segment files of each topic,
+// every subscriber reads the topic in order from its own offset saved on disk, so the messages
+// not consumed yet are redelivered after restart.
+//
+// Layout of directory:
+//
+//	<path>/<escaped topic>/<base offset>.v1.seg frames starting at base offset, .seg of legacy frames
+//	<path>/<escaped topic>/<subscriber>.offset  offset of the next frame to handle
+//	<path>/%zz-delayed/<due>-<random>           delayed message appended to topic once due
+type DiskQueue struct {
+	sync.Mutex
+	path          string
+	segmentSize   int64         // a new segment is rolled once the active one exceeds
+	fsync         bool          // fsync segment on every publish
+	retryInterval time.Duration // interval between retries of subscriptions without retry policy
+	topics        map[string]*diskTopic
+	subs          *topicTrie[*diskSubscription]
+	counts        map[string]int // number of subscriptions of each filter made by Subscribe
+	expiredTopic  string         // topic the expired messages published to, dropped if empty
+	delayed       []diskDelayed  // delayed messages in the order of due time
+	delayWake     chan struct{}
+	wg            sync.WaitGroup
+	closer        chan struct{}
+}
+
+type DiskQueueOption func(dq *DiskQueue)
+
+// DiskQueWithSegmentSize sets the size segment files roll at, 64MB by default.
+func DiskQueWithSegmentSize(size int64) DiskQueueOption {
+	return func(dq *DiskQueue) {
+		if size > 0 {
+			dq.segmentSize = size
+		}
+	}
+}
+
+// DiskQueWithFsync fsyncs segment file on every publish.
+func DiskQueWithFsync() DiskQueueOption {
+	return func(dq *DiskQueue) {
+		dq.fsync = true
+	}
+}
+
+// DiskQueWithRetryInterval sets the interval between retries of subscriptions without retry policy, 100ms by default.
+// Non-positive durations are ignored.
+func DiskQueWithRetryInterval(d time.Duration) DiskQueueOption {
+	return func(dq *DiskQueue) {
+		if d > 0 {
+			dq.retryInterval = d
+		}
+	}
+}
+
+// DiskQueWithExpiredTopic publishes the messages expired by TTL to topic as DeadLetter instead of dropping them,
+// a message expires once among the subscribers while queue is open, it may expire again after reopening
+// for the subscribers not read past it yet.
+func DiskQueWithExpiredTopic(topic string) DiskQueueOption {
+	return func(dq *DiskQueue) {
+		dq.expiredTopic = topic
+	}
+}
+
+// diskTopic is the segments of a topic, offset is the byte position across segments.
+type diskTopic struct {
+	sync.Mutex
+	topic    string
+	path     string
+	bases    []int64        // base offsets of segments in order
+	legacy   map[int64]bool // base offsets of unversioned segments of legacy frames
+	active   *os.File
+	end      int64 // offset of the next frame appended
+	subs     map[string]*diskSubscriber
+	offsets  map[string]int64 // offsets of all subscribers saved, attached or not, segments are kept for them
+	expired  map[int64]bool   // offsets of messages expired already, until every subscriber read past
+	appended chan struct{}    // closed on every append to wake up subscribers
+}
+
+// diskSubscription is a subscription of topic filter, it has a diskSubscriber in each topic matched.
+type diskSubscription struct {
+	filter     string
+	name       string
+	handler    MessageHandler
+	retry      RetryPolicy
+	deadLetter string // topic the messages exhausted retries published to, dropped if empty
+}
+
+type diskSubscriber struct {
+	*diskSubscription
+	offset  int64    // offset of the next frame to handle, guarded by topic lock
+	seg     *os.File // segment reading
+	segBase int64
+	stop    chan struct{} // closed once unsubscribed
+}
+
+// Publish appends message to the active segment of topic, the delayed message is saved aside
+// and appended once due.
+func (dq *DiskQueue) Publish(topic string, bts []byte, opts ...PublishOption) error {
+	if err := validTopic(topic); err != nil {
+		return err
+	}
+	msg, err := newMessage(bts, opts...)
+	if err != nil {
+		return err
+	}
+	select {
+	case <-dq.closer:
+		return ErrQueueClosed
+	default:
+	}
+	if msg.due().After(time.Now()) {
+		return dq.delay(topic, msg)
+	}
+
+	dq.Lock()
+	dt, err := dq.topic(topic)
+	dq.Unlock()
+	if err != nil {
+		return err
+	}
+
+	return dt.append(msg, dq.segmentSize, dq.fsync)
+}
+
+// Subscribe subscribes topic filter with the name sub-<n>, n counts the subscriptions of filter from 0,
+// so the offsets are picked up again if the subscriptions are made in the same order after restart.
+// The name of wildcard filter is suffixed by @<escaped filter> to keep apart from plain topics.
+func (dq *DiskQueue) Subscribe(filter string, handler MessageHandler, opts ...SubscribeOption) {
+	dq.Lock()
+	name := fmt.Sprintf("sub-%d", dq.counts[filter])
+	dq.counts[filter]++
+	dq.Unlock()
+	if isWildcard(filter) {
+		name += "@" + url.PathEscape(filter)
+	}
+
+	if err := dq.SubscribeNamed(filter, name, handler, opts...); err != nil {
+		log.Println(err.Error())
+	}
+}
+
+// SubscribeNamed subscribes topic filter by name, the subscriber reads every topic matched on its own
+// including the ones published first later. It resumes from the offset saved by the same name in
+// each topic or starts from the earliest message on disk. The failed messages are retried every retry
+// interval of queue until MaxRetry reached if no retry policy given, messages of the topic wait behind.
+func (dq *DiskQueue) SubscribeNamed(filter, name string, handler MessageHandler, opts ...SubscribeOption) error {
+	if name == "" || strings.ContainsAny(name, `/\`) {
+		return ErrInvalidSubscriber
+	}
+	if err := validFilter(filter); err != nil {
+		return err
+	}
+	select {
+	case <-dq.closer:
+		return ErrQueueClosed
+	default:
+	}
+
+	dq.Lock()
+	defer dq.Unlock()
+
+	topics, err := dq.listTopics()
+	if err != nil {
+		return err
+	}
+	if !isWildcard(filter) && !slices.Contains(topics, filter) {
+		topics = append(topics, filter)
+	}
+	var matched []string
+	for _, topic := range topics {
+		if !matchFilter(filter, topic) {
+			continue
+		}
+		if dt, ok := dq.topics[topic]; ok {
+			dt.Lock()
+			_, dup := dt.subs[name]
+			dt.Unlock()
+			if dup {
+				return fmt.Errorf("%w: %s subscribed %s already", ErrInvalidSubscriber, name, topic)
+			}
+		}
+		matched = append(matched, topic)
+	}
+
+	so := &subscribeOptions{retry: RetryWithInterval(MaxRetry, dq.retryInterval)}
+	for _, opt := range opts {
+		opt(so)
+	}
+	sub := &diskSubscription{filter: filter, name: name, handler: handler, retry: so.retry, deadLetter: so.deadLetter}
+	dq.subs.insert(filter, sub)
+	for _, topic := range matched {
+		if dt, ok := dq.topics[topic]; ok {
+			err = dq.attach(dt, sub)
+		} else {
+			// subscription is attached while opening topic
+			_, err = dq.topic(topic)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Unsubscribe removes the subscription of filter by name, its subscribers stop consuming and the offsets
+// saved in every topic matched are deleted so the segments kept for them are collected. It also cleans
+// up the offsets left by name on disk while not subscribed. A message in handling is not interrupted.
+func (dq *DiskQueue) Unsubscribe(filter, name string) error {
+	if name == "" || strings.ContainsAny(name, `/\`) {
+		return ErrInvalidSubscriber
+	}
+	if err := validFilter(filter); err != nil {
+		return err
+	}
+	select {
+	case <-dq.closer:
+		return ErrQueueClosed
+	default:
+	}
+
+	dq.Lock()
+	defer dq.Unlock()
+
+	dq.subs.remove(filter, func(sub *diskSubscription) bool { return sub.name == name })
+	topics, err := dq.listTopics()
+	if err != nil {
+		return err
+	}
+	for _, topic := range topics {
+		if !matchFilter(filter, topic) {
+			continue
+		}
+		dt, err := dq.topic(topic)
+		if err != nil {
+			return err
+		}
+		if err = dt.detach(filter, name); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// attach starts consuming topic for subscription.
+func (dq *DiskQueue) attach(dt *diskTopic, sub *diskSubscription) error {
+	dt.Lock()
+	if _, ok := dt.subs[sub.name]; ok {
+		dt.Unlock()
+
+		return fmt.Errorf("%w: %s subscribed already", ErrInvalidSubscriber, sub.name)
+	}
+	ds := &diskSubscriber{diskSubscription: sub, offset: dt.bases[0], stop: make(chan struct{})}
+	if offset, ok := dt.offsets[sub.name]; ok {
+		ds.offset = offset
+	}
+	if ds.offset < dt.bases[0] {
+		log.Printf("messages of %s lost for %s: offset %d before the oldest segment %d", dt.topic, sub.name, ds.offset, dt.bases[0])
+		ds.offset = dt.bases[0]
+	}
+	dt.subs[sub.name] = ds
+	dt.offsets[sub.name] = ds.offset
+	dt.Unlock()
+
+	dq.wg.Add(1)
+	go func() {
+		defer dq.wg.Done()
+		dq.consume(dt, ds)
+	}()
+
+	return nil
+}
+
+// consume handles the messages of topic one by one from the offset of sub.
+func (dq *DiskQueue) consume(dt *diskTopic, sub *diskSubscriber) {
+	defer func() {
+		if sub.seg != nil {
+			sub.seg.Close()
+		}
+	}()
+
+	for {
+		msg, next, appended, err := dt.read(sub)
+		if err != nil {
+			log.Println(err.Error())
+			select {
+			case <-dq.closer:
+				return
+			case <-sub.stop:
+				return
+			case <-time.After(dq.retryInterval):
+			}
+
+			continue
+		}
+		if msg == nil {
+			select {
+			case <-dq.closer:
+				return
+			case <-sub.stop:
+				return
+			case <-appended:
+			}
+
+			continue
+		}
+
+		if !dq.handle(dt, sub, msg) {
+			return
+		}
+		if err = dt.commit(sub, next); err != nil {
+			log.Println(err.Error())
+		}
+	}
+}
+
+// handle calls handler until message succeeds or retries run out, failures are counted per
+// subscriber so one subscriber never uses up the retries of others. It returns false if
+// queue closed while retrying.
+func (dq *DiskQueue) handle(dt *diskTopic, sub *diskSubscriber, msg message) bool {
+	_, _, _, payload, err := msg.parse()
+	if err != nil {
+		log.Println(err.Error())
+
+		return true
+	}
+
+	var failed uint8
+	for {
+		if msg.expired(time.Now()) {
+			if dt.expireOnce(sub.offset) {
+				dq.expire(dt.topic, msg, payload)
+			}
+
+			return true
+		}
+		if err = sub.handler.HandleMessage(payload); err == nil {
+			return true
+		}
+
+		failed++
+		if sub.retry.exhausted(failed, failed != 0) {
+			dq.deadLetter(sub, dt.topic, payload, int(failed), err)
+
+			return true
+		}
+
+		timer := time.NewTimer(sub.retry.backoff(int(failed)))
+		select {
+		case <-dq.closer:
+			timer.Stop()
+
+			return false
+		case <-sub.stop:
+			timer.Stop()
+
+			return false
+		case <-timer.C:
+		}
+	}
+}
+
+// deadLetter publishes the message exhausted its retries to the dead-letter topic of subscription.
+func (dq *DiskQueue) deadLetter(sub *diskSubscriber, topic string, payload []byte, attempts int, reason error) {
+	if sub.deadLetter == "" {
+		log.Printf("drop message after %d attempts: %s", attempts, reason.Error())
+
+		return
+	}
+
+	dl := &DeadLetter{Topic: topic, Reason: reason.Error(), Attempts: attempts, FailedAt: time.Now(), Payload: payload}
+	bts, err := dl.Encode()
+	if err == nil {
+		err = dq.Publish(sub.deadLetter, bts)
+	}
+	if err != nil && !errors.Is(err, ErrQueueClosed) {
+		log.Println(err.Error())
+	}
+}
+
+func (dq *DiskQueue) expire(topic string, msg message, payload []byte) {
+	if dq.expiredTopic == "" {
+		log.Println("drop expired message")
+
+		return
+	}
+
+	bts, err := expiredLetter(topic, msg, payload)
+	if err != nil {
+		log.Println(err.Error())
+
+		return
+	}
+	if err = dq.Publish(dq.expiredTopic, bts); err != nil && !errors.Is(err, ErrQueueClosed) {
+		log.Println(err.Error())
+	}
+}
+
+// topic returns the topic opened or opens it from disk and attaches the subscriptions matched,
+// dq must be locked.
+func (dq *DiskQueue) topic(topic string) (*diskTopic, error) {
+	if dt, ok := dq.topics[topic]; ok {
+		return dt, nil
+	}
+	dt, err := openDiskTopic(filepath.Join(dq.path, url.PathEscape(topic)))
+	if err != nil {
+		return nil, err
+	}
+	dt.topic = topic
+	dq.topics[topic] = dt
+
+	for _, sub := range dq.subs.match(topic) {
+		if err = dq.attach(dt, sub); err != nil {
+			log.Println(err.Error())
+		}
+	}
+
+	return dt, nil
+}
+
+// listTopics returns the topics saved on disk.
+func (dq *DiskQueue) listTopics() ([]string, error) {
+	entries, err := os.ReadDir(dq.path)
+	if err != nil {
+		return nil, err
+	}
+
+	var topics []string
+	for _, entry := range entries {
+		if !entry.IsDir() || entry.Name() == _delayedDir {
+			continue
+		}
+		topic, err := url.PathUnescape(entry.Name())
+		if err != nil || validTopic(topic) != nil {
+			continue
+		}
+		topics = append(topics, topic)
+	}
+
+	return topics, nil
+}
+
+func (dt *diskTopic) append(msg message, segmentSize int64, fsync bool) error {
+	dt.Lock()
+	defer dt.Unlock()
+
+	if dt.end > dt.bases[len(dt.bases)-1] && dt.end-dt.bases[len(dt.bases)-1]+int64(msg.len()) > segmentSize {
+		if err := dt.roll(); err != nil {
+			return err
+		}
+	}
+	if _, err := dt.active.Write(msg); err != nil {
+		return err
+	}
+	if fsync {
+		if err := dt.active.Sync(); err != nil {
+			return err
+		}
+	}
+	dt.end += int64(msg.len())
+
+	close(dt.appended)
+	dt.appended = make(chan struct{})
+
+	return nil
+}
+
+func (dt *diskTopic) roll() error {
+	f, err := os.OpenFile(segmentPath(dt.path, dt.end), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	if err = dt.active.Close(); err != nil {
+		log.Println(err.Error())
+	}
+	dt.active = f
+	dt.bases = append(dt.bases, dt.end)
+
+	return nil
+}
+
+// read returns the frame at the offset of sub with its position in segment and the offset after it,
+// nil message is returned along with the channel closed by next append if nothing to read.
+func (dt *diskTopic) read(sub *diskSubscriber) (msg message, next int64, appended chan struct{}, err error) {
+	dt.Lock()
+	offset := sub.offset
+	appended = dt.appended
+	if offset >= dt.end {
+		dt.Unlock()
+
+		return nil, 0, appended, nil
+	}
+	i := sort.Search(len(dt.bases), func(i int) bool { return dt.bases[i] > offset }) - 1
+	base, legacy := dt.bases[i], dt.legacy[dt.bases[i]]
+	dt.Unlock()
+
+	if sub.seg == nil || sub.segBase != base {
+		if sub.seg != nil {
+			sub.seg.Close()
+		}
+		if sub.seg, err = os.Open(dt.segmentPath(base, legacy)); err != nil {
+			sub.seg = nil
+
+			return nil, 0, appended, err
+		}
+		sub.segBase = base
+	}
+
+	headerLen := HeaderLen
+	if legacy {
+		headerLen = _legacyHeaderLen
+	}
+	pos := offset - base
+	header := make([]byte, headerLen)
+	if _, err = sub.seg.ReadAt(header, pos); err != nil {
+		return nil, 0, appended, err
+	}
+	frame := make([]byte, headerLen+int(binary.BigEndian.Uint32(header)))
+	if _, err = sub.seg.ReadAt(frame, pos); err != nil {
+		return nil, 0, appended, err
+	}
+	if msg = frame; legacy {
+		msg = upgradeLegacy(frame)
+	}
+
+	return msg, offset + int64(len(frame)), appended, nil
+}
+
+// commit saves the offset of sub and removes the segments consumed by all subscribers.
+func (dt *diskTopic) commit(sub *diskSubscriber, offset int64) error {
+	dt.Lock()
+	defer dt.Unlock()
+
+	if dt.subs[sub.name] != sub {
+		// unsubscribed while handling
+		return nil
+	}
+	sub.offset = offset
+	if err := saveOffset(dt.path, sub.name, offset); err != nil {
+		return err
+	}
+
+	dt.offsets[sub.name] = offset
+
+	return dt.collect()
+}
+
+// detach stops the subscriber of filter by name and deletes its offset, the offset saved by name
+// is deleted as well if no subscriber attached.
+func (dt *diskTopic) detach(filter, name string) error {
+	dt.Lock()
+	defer dt.Unlock()
+
+	if sub, ok := dt.subs[name]; ok {
+		if sub.filter != filter {
+			return nil
+		}
+		close(sub.stop)
+		delete(dt.subs, name)
+	}
+	delete(dt.offsets, name)
+	if err := os.Remove(filepath.Join(dt.path, name+_offsetSuffix)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	return dt.collect()
+}
+
+// expireOnce reports whether the message at offset is expired for the first time among subscribers.
+func (dt *diskTopic) expireOnce(offset int64) bool {
+	dt.Lock()
+	defer dt.Unlock()
+
+	if dt.expired[offset] {
+		return false
+	}
+	dt.expired[offset] = true
+
+	return true
+}
+
+// collect removes the segments all the subscribers read past, dt must be locked.
+func (dt *diskTopic) collect() error {
+	if len(dt.offsets) == 0 {
+		return nil
+	}
+	low := dt.end
+	for _, o := range dt.offsets {
+		low = min(low, o)
+	}
+	for offset := range dt.expired {
+		if offset < low {
+			delete(dt.expired, offset)
+		}
+	}
+	for len(dt.bases) > 1 && dt.bases[1] <= low {
+		if err := os.Remove(dt.segmentPath(dt.bases[0], dt.legacy[dt.bases[0]])); err != nil {
+			return err
+		}
+		delete(dt.legacy, dt.bases[0])
+		dt.bases = dt.bases[1:]
+	}
+
+	return nil
+}
+
+func (dt *diskTopic) close() error {
+	dt.Lock()
+	defer dt.Unlock()
+
+	return dt.active.Close()
+}
+
+// openDiskTopic loads the segments and subscriber offsets of topic, the torn frame at the end of the last segment is truncated.
+func openDiskTopic(path string) (*diskTopic, error) {
+	if err := os.MkdirAll(path, 0755); err != nil {
+		return nil, err
+	}
+	entries, err := os.ReadDir(path)
+	if err != nil {
+		return nil, err
+	}
+
+	dt := &diskTopic{
+		path:     path,
+		legacy:   make(map[int64]bool),
+		subs:     make(map[string]*diskSubscriber),
+		offsets:  make(map[string]int64),
+		expired:  make(map[int64]bool),
+		appended: make(chan struct{}),
+	}
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		if name, ok := strings.CutSuffix(entry.Name(), _offsetSuffix); ok {
+			bts, err := os.ReadFile(filepath.Join(path, entry.Name()))
+			if err != nil {
+				return nil, err
+			}
+			if dt.offsets[name], err = strconv.ParseInt(string(bts), 10, 64); err != nil {
+				return nil, err
+			}
+
+			continue
+		}
+		name, ok := strings.CutSuffix(entry.Name(), _segmentSuffix)
+		if !ok {
+			continue
+		}
+		name, v1 := strings.CutSuffix(name, _segmentV1)
+		base, err := strconv.ParseInt(name, 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		dt.bases = append(dt.bases, base)
+		if !v1 {
+			dt.legacy[base] = true
+		}
+	}
+	sort.Slice(dt.bases, func(i, j int) bool { return dt.bases[i] < dt.bases[j] })
+	if len(dt.bases) == 0 {
+		dt.bases = []int64{0}
+	}
+
+	last := dt.bases[len(dt.bases)-1]
+	legacy := dt.legacy[last]
+	if dt.active, err = os.OpenFile(dt.segmentPath(last, legacy), os.O_CREATE|os.O_RDWR, 0644); err != nil {
+		return nil, err
+	}
+	headerLen := HeaderLen
+	if legacy {
+		headerLen = _legacyHeaderLen
+	}
+	size, err := recoverSegment(dt.active, int64(headerLen))
+	if err != nil {
+		dt.active.Close()
+
+		return nil, err
+	}
+	if _, err = dt.active.Seek(size, io.SeekStart); err != nil {
+		dt.active.Close()
+
+		return nil, err
+	}
+	dt.end = last + size
+
+	// frames are never appended to legacy segment
+	if legacy {
+		if size == 0 {
+			dt.active.Close()
+			if err = os.Remove(dt.segmentPath(last, true)); err != nil {
+				return nil, err
+			}
+			delete(dt.legacy, last)
+			if dt.active, err = os.OpenFile(segmentPath(path, last), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644); err != nil {
+				return nil, err
+			}
+		} else if err = dt.roll(); err != nil {
+			dt.active.Close()
+
+			return nil, err
+		}
+	}
+
+	return dt, nil
+}
+
+// recoverSegment returns the size of the complete frames in f and truncates the rest.
+func recoverSegment(f *os.File, headerLen int64) (int64, error) {
+	fi, err := f.Stat()
+	if err != nil {
+		return 0, err
+	}
+
+	var (
+		pos    int64
+		header = make([]byte, headerLen)
+	)
+	for pos+headerLen <= fi.Size() {
+		if _, err = f.ReadAt(header, pos); err != nil {
+			return 0, err
+		}
+		n := headerLen + int64(binary.BigEndian.Uint32(header))
+		if pos+n > fi.Size() {
+			break
+		}
+		pos += n
+	}
+	if pos != fi.Size() {
+		log.Printf("truncate torn frame of %s at %d", f.Name(), pos)
+		if err = f.Truncate(pos); err != nil {
+			return 0, err
+		}
+	}
+
+	return pos, nil
+}
+
+func segmentPath(path string, base int64) string {
+	return filepath.Join(path, fmt.Sprintf("%020d%s%s", base, _segmentV1, _segmentSuffix))
+}
+
+func (dt *diskTopic) segmentPath(base int64, legacy bool) string {
+	if legacy {
+		return filepath.Join(dt.path, fmt.Sprintf("%020d%s", base, _segmentSuffix))
+	}
+
+	return segmentPath(dt.path, base)
+}
+
+// saveOffset writes offset into a temporary file and renames it so the offset file is never torn.
+func saveOffset(path, name string, offset int64) error {
+	tmp := filepath.Join(path, name+_offsetSuffix+".tmp")
+	if err := os.WriteFile(tmp, []byte(strconv.FormatInt(offset, 10)), 0644); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, filepath.Join(path, name+_offsetSuffix))
+}
+
+// Close stops consuming and waits for the handlers running to return.
+func (dq *DiskQueue) Close() error {
+	select {
+	case <-dq.closer:
+		return nil
+	default:
+		close(dq.closer)
+	}
+	dq.wg.Wait()
+
+	dq.Lock()
+	defer dq.Unlock()
+
+	var errs []error
+	for _, dt := range dq.topics {
+		if err := dt.close(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// OpenDiskQueue opens the queue saved in path, topics are loaded on the first publish or subscription
+// and delayed messages are scheduled again.
+func OpenDiskQueue(path string, opts ...DiskQueueOption) (*DiskQueue, error) {
+	if err := os.MkdirAll(path, 0755); err != nil {
+		return nil, err
+	}
+
+	dq := &DiskQueue{
+		path:          path,
+		segmentSize:   64 << 20,
+		retryInterval: 100 * time.Millisecond,
+		topics:        make(map[string]*diskTopic),
+		subs:          newTopicTrie[*diskSubscription](),
+		counts:        make(map[string]int),
+		delayWake:     make(chan struct{}, 1),
+		closer:        make(chan struct{}),
+	}
+	for _, opt := range opts {
+		opt(dq)
+	}
+	if err := dq.loadDelayed(); err != nil {
+		return nil, err
+	}
+
+	dq.wg.Add(1)
+	go func() {
+		defer dq.wg.Done()
+		dq.runDelayed()
+	}()
+
+	return dq, nil
+}
@@ -0,0 +1,566 @@
+/*
+ *   Copyright (c) 2023 CodapeWild
+ *   All rights reserved.
+
+ *   Licensed under the Apache License, Version 2.0 (the "License");
+ *   you may not use this file except in compliance with the License.
+ *   You may obtain a copy of the License at
+
+ *   http://www.apache.org/licenses/LICENSE-2.0
+
+ *   Unless required by applicable law or agreed to in writing, software
+ *   distributed under the License is distributed on an "AS IS" BASIS,
+ *   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *   See the License for the specific language governing permissions and
+ *   limitations under the License.
+ */
+
+package msque
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestDiskQueueRecover(t *testing.T) {
+	// every segment holds two frames of one byte payload
+	var (
+		path    = t.TempDir()
+		segSize = int64(2 * (HeaderLen + 1))
+	)
+	dq, err := OpenDiskQueue(path, DiskQueWithSegmentSize(segSize), DiskQueWithRetryInterval(time.Millisecond))
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	for _, s := range []string{"1", "2", "3", "4"} {
+		if err = dq.Publish("events", []byte(s)); err != nil {
+			t.Fatal(err.Error())
+		}
+	}
+
+	// handles two messages then blocks until closed
+	var (
+		received = make(chan string, 4)
+		block    = make(chan struct{})
+		handled  int
+	)
+	err = dq.SubscribeNamed("events", "svc", MessageHandlerFunc(func(bts []byte) error {
+		if handled == 2 {
+			<-block
+
+			return errors.New("closing")
+		}
+		handled++
+		received <- string(bts)
+
+		return nil
+	}))
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	for i := 0; i < 2; i++ {
+		select {
+		case <-received:
+		case <-time.After(time.Second):
+			t.Fatal("expect message delivered")
+		}
+	}
+	time.Sleep(10 * time.Millisecond)
+	close(block)
+	dq.Close()
+
+	// the first segment holding message 1 and 2 is consumed and removed
+	if _, err = os.Stat(segmentPath(filepath.Join(path, "events"), 0)); !os.IsNotExist(err) {
+		t.Fatalf("expect first segment removed got %v", err)
+	}
+
+	// append a torn frame which is truncated on reopen
+	f, err := os.OpenFile(segmentPath(filepath.Join(path, "events"), segSize), os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	f.Write([]byte{0, 0, 0, 9, 1})
+	f.Close()
+
+	if dq, err = OpenDiskQueue(path, DiskQueWithSegmentSize(segSize)); err != nil {
+		t.Fatal(err.Error())
+	}
+	defer dq.Close()
+
+	got := make(chan string, 4)
+	if err = dq.SubscribeNamed("events", "svc", MessageHandlerFunc(func(bts []byte) error {
+		got <- string(bts)
+
+		return nil
+	})); err != nil {
+		t.Fatal(err.Error())
+	}
+	if err = dq.Publish("events", []byte("5")); err != nil {
+		t.Fatal(err.Error())
+	}
+	for _, expect := range []string{"3", "4", "5"} {
+		select {
+		case msg := <-got:
+			if msg != expect {
+				t.Fatalf("expect %s got %s", expect, msg)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("expect %s delivered", expect)
+		}
+	}
+}
+
+func TestDiskQueueSubscribers(t *testing.T) {
+	dq, err := OpenDiskQueue(t.TempDir())
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer dq.Close()
+
+	received := make(chan string, 4)
+	for _, name := range []string{"a", "b"} {
+		dq.SubscribeNamed("orders", name, MessageHandlerFunc(func(bts []byte) error {
+			received <- name + ":" + string(bts)
+
+			return nil
+		}))
+	}
+	if err = dq.SubscribeNamed("orders", "a", MessageHandlerFunc(func([]byte) error { return nil })); !errors.Is(err, ErrInvalidSubscriber) {
+		t.Fatalf("expect ErrInvalidSubscriber got %v", err)
+	}
+	if err = dq.Publish("orders", []byte("1")); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	got := make(map[string]bool)
+	for i := 0; i < 2; i++ {
+		select {
+		case msg := <-received:
+			got[msg] = true
+		case <-time.After(time.Second):
+			t.Fatalf("expect 2 messages got %v", got)
+		}
+	}
+	if !got["a:1"] || !got["b:1"] {
+		t.Fatalf("unexpected messages %v", got)
+	}
+}
+
+func TestDiskQueueWildcard(t *testing.T) {
+	path := t.TempDir()
+	dq, err := OpenDiskQueue(path)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer dq.Close()
+
+	if err = dq.Publish("devices/1/telemetry", []byte("1")); err != nil {
+		t.Fatal(err.Error())
+	}
+	received := make(chan string, 4)
+	dq.Subscribe("devices/+/telemetry", MessageHandlerFunc(func(bts []byte) error {
+		received <- string(bts)
+
+		return nil
+	}))
+	// topics published first after subscription are matched as well
+	for topic, payload := range map[string]string{"devices/2/telemetry": "2", "devices/1/status": "3"} {
+		if err = dq.Publish(topic, []byte(payload)); err != nil {
+			t.Fatal(err.Error())
+		}
+	}
+	if err = dq.Publish("devices/+/telemetry", []byte("4")); !errors.Is(err, ErrInvalidTopic) {
+		t.Fatalf("expect ErrInvalidTopic got %v", err)
+	}
+
+	got := make(map[string]bool)
+	for i := 0; i < 2; i++ {
+		select {
+		case msg := <-received:
+			got[msg] = true
+		case <-time.After(time.Second):
+			t.Fatalf("expect 2 messages got %v", got)
+		}
+	}
+	select {
+	case msg := <-received:
+		t.Fatalf("unexpected message %s", msg)
+	case <-time.After(20 * time.Millisecond):
+	}
+	if !got["1"] || !got["2"] {
+		t.Fatalf("unexpected messages %v", got)
+	}
+}
+
+func TestDiskQueueDelayAndTTL(t *testing.T) {
+	path := t.TempDir()
+	dq, err := OpenDiskQueue(path, DiskQueWithExpiredTopic("expired"))
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if err = dq.Publish("jobs", []byte("delayed"), PublishWithDelay(50*time.Millisecond)); err != nil {
+		t.Fatal(err.Error())
+	}
+	dq.Close()
+
+	// the delayed message survives restart
+	if dq, err = OpenDiskQueue(path, DiskQueWithExpiredTopic("expired")); err != nil {
+		t.Fatal(err.Error())
+	}
+	defer dq.Close()
+
+	received := make(chan string, 4)
+	for _, topic := range []string{"jobs", "jobs", "expired"} {
+		dq.Subscribe(topic, MessageHandlerFunc(func(bts []byte) error {
+			if topic == "expired" {
+				dl := &DeadLetter{}
+				if err := dl.Decode(bts); err != nil {
+					return err
+				}
+				bts = []byte(dl.Topic + ":" + dl.Reason + ":" + string(dl.Payload))
+			}
+			received <- topic + ":" + string(bts)
+
+			return nil
+		}))
+	}
+	if err = dq.Publish("jobs", []byte("stale"), PublishWithTTL(time.Nanosecond)); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	start := time.Now()
+	// both subscribers of jobs see the stale message but it expires once
+	for _, expect := range []string{"expired:jobs:expired:stale", "jobs:delayed", "jobs:delayed"} {
+		select {
+		case msg := <-received:
+			if msg != expect {
+				t.Fatalf("expect %s got %s", expect, msg)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("expect %s delivered", expect)
+		}
+	}
+	select {
+	case msg := <-received:
+		t.Fatalf("unexpected message %s", msg)
+	case <-time.After(20 * time.Millisecond):
+	}
+	if time.Since(start) < 20*time.Millisecond {
+		t.Fatal("expect delayed message delivered once due")
+	}
+}
+
+func TestDiskQueueDeadLetter(t *testing.T) {
+	dq, err := OpenDiskQueue(t.TempDir())
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer dq.Close()
+
+	var (
+		attempts    int
+		deadLetters = make(chan *DeadLetter, 1)
+	)
+	dq.Subscribe("orders/+", MessageHandlerFunc(func(bts []byte) error {
+		attempts++
+
+		return errors.New("poison")
+	}), SubscribeWithRetry(RetryWithBackoff(3, time.Millisecond, 2*time.Millisecond)), SubscribeWithDeadLetter("dlq"))
+	dq.Subscribe("dlq", MessageHandlerFunc(func(bts []byte) error {
+		dl := &DeadLetter{}
+		if err := dl.Decode(bts); err != nil {
+			return err
+		}
+		deadLetters <- dl
+
+		return nil
+	}))
+	dq.Publish("orders/1", []byte("1"))
+
+	select {
+	case dl := <-deadLetters:
+		if dl.Topic != "orders/1" || dl.Reason != "poison" || dl.Attempts != 3 || string(dl.Payload) != "1" {
+			t.Fatalf("unexpected dead letter %+v", dl)
+		}
+		if attempts != 3 {
+			t.Fatalf("expect 3 attempts got %d", attempts)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expect dead letter published")
+	}
+}
+
+func TestDiskQueueRetryPerSubscriber(t *testing.T) {
+	dq, err := OpenDiskQueue(t.TempDir())
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer dq.Close()
+
+	deadLetters := make(chan *DeadLetter, 2)
+	attempts := make(map[string]*atomic.Int32)
+	for _, name := range []string{"a", "b"} {
+		counter := &atomic.Int32{}
+		attempts[name] = counter
+		dq.SubscribeNamed("orders", name, MessageHandlerFunc(func(bts []byte) error {
+			counter.Add(1)
+
+			return errors.New("poison")
+		}), SubscribeWithRetry(RetryWithInterval(5, time.Millisecond)), SubscribeWithDeadLetter("dlq"))
+	}
+	dq.Subscribe("dlq", MessageHandlerFunc(func(bts []byte) error {
+		dl := &DeadLetter{}
+		if err := dl.Decode(bts); err != nil {
+			return err
+		}
+		deadLetters <- dl
+
+		return nil
+	}))
+	dq.Publish("orders", []byte("1"))
+
+	for i := 0; i < 2; i++ {
+		select {
+		case dl := <-deadLetters:
+			if dl.Attempts != 5 {
+				t.Fatalf("expect 5 attempts got %d", dl.Attempts)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("expect dead letter of each subscriber")
+		}
+	}
+	for name, counter := range attempts {
+		if counter.Load() != 5 {
+			t.Fatalf("expect 5 attempts of %s got %d", name, counter.Load())
+		}
+	}
+}
+
+func TestDiskQueueDetachedSubscriber(t *testing.T) {
+	var (
+		path    = t.TempDir()
+		segSize = int64(HeaderLen + 1)
+	)
+	dq, err := OpenDiskQueue(path, DiskQueWithSegmentSize(segSize))
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	got := make(chan string, 8)
+	for _, name := range []string{"a", "b"} {
+		dq.SubscribeNamed("events", name, MessageHandlerFunc(func(bts []byte) error {
+			got <- name + ":" + string(bts)
+
+			return nil
+		}))
+	}
+	dq.Publish("events", []byte("1"))
+	for i := 0; i < 2; i++ {
+		select {
+		case <-got:
+		case <-time.After(time.Second):
+			t.Fatal("expect message delivered")
+		}
+	}
+	dq.Close()
+
+	// only a resubscribes, the segments unread by b are kept
+	if dq, err = OpenDiskQueue(path, DiskQueWithSegmentSize(segSize)); err != nil {
+		t.Fatal(err.Error())
+	}
+	dq.SubscribeNamed("events", "a", MessageHandlerFunc(func(bts []byte) error {
+		got <- "a:" + string(bts)
+
+		return nil
+	}))
+	for _, s := range []string{"2", "3"} {
+		dq.Publish("events", []byte(s))
+	}
+	for i := 0; i < 2; i++ {
+		select {
+		case <-got:
+		case <-time.After(time.Second):
+			t.Fatal("expect message delivered")
+		}
+	}
+	dq.Close()
+
+	if dq, err = OpenDiskQueue(path, DiskQueWithSegmentSize(segSize)); err != nil {
+		t.Fatal(err.Error())
+	}
+	defer dq.Close()
+	dq.SubscribeNamed("events", "b", MessageHandlerFunc(func(bts []byte) error {
+		got <- "b:" + string(bts)
+
+		return nil
+	}))
+	for _, expect := range []string{"b:2", "b:3"} {
+		select {
+		case msg := <-got:
+			if msg != expect {
+				t.Fatalf("expect %s got %s", expect, msg)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("expect %s delivered", expect)
+		}
+	}
+}
+
+func TestDiskQueueUnsubscribe(t *testing.T) {
+	var (
+		path    = t.TempDir()
+		segSize = int64(HeaderLen + 1)
+	)
+	dq, err := OpenDiskQueue(path, DiskQueWithSegmentSize(segSize))
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	got := make(chan string, 8)
+	for _, name := range []string{"a", "b"} {
+		dq.SubscribeNamed("events", name, MessageHandlerFunc(func(bts []byte) error {
+			got <- name + ":" + string(bts)
+
+			return nil
+		}))
+	}
+	dq.Publish("events", []byte("1"))
+	for i := 0; i < 2; i++ {
+		select {
+		case <-got:
+		case <-time.After(time.Second):
+			t.Fatal("expect message delivered")
+		}
+	}
+	dq.Close()
+
+	// b never comes back, its offset keeps the segments until unsubscribed
+	if dq, err = OpenDiskQueue(path, DiskQueWithSegmentSize(segSize)); err != nil {
+		t.Fatal(err.Error())
+	}
+	defer dq.Close()
+	dq.SubscribeNamed("events", "a", MessageHandlerFunc(func(bts []byte) error {
+		got <- "a:" + string(bts)
+
+		return nil
+	}))
+	for _, s := range []string{"2", "3"} {
+		dq.Publish("events", []byte(s))
+	}
+	for i := 0; i < 2; i++ {
+		select {
+		case <-got:
+		case <-time.After(time.Second):
+			t.Fatal("expect message delivered")
+		}
+	}
+	if err = dq.Unsubscribe("events", "b"); err != nil {
+		t.Fatal(err.Error())
+	}
+	if _, err = os.Stat(filepath.Join(path, "events", "b"+_offsetSuffix)); !os.IsNotExist(err) {
+		t.Fatal("expect offset of b deleted")
+	}
+	for deadline := time.Now().Add(time.Second); ; time.Sleep(10 * time.Millisecond) {
+		segs, err := filepath.Glob(filepath.Join(path, "events", "*"+_segmentSuffix))
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		if len(segs) == 1 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expect segments read by a collected, %d left", len(segs))
+		}
+	}
+
+	if err = dq.Unsubscribe("events", "a"); err != nil {
+		t.Fatal(err.Error())
+	}
+	dq.Publish("events", []byte("4"))
+	select {
+	case msg := <-got:
+		t.Fatalf("expect no message after unsubscribed got %s", msg)
+	case <-time.After(50 * time.Millisecond):
+	}
+	if _, err = os.Stat(filepath.Join(path, "events", "a"+_offsetSuffix)); !os.IsNotExist(err) {
+		t.Fatal("expect offset of a deleted")
+	}
+}
+
+func TestDiskQueueDelayedDir(t *testing.T) {
+	path := t.TempDir()
+	dq, err := OpenDiskQueue(path)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer dq.Close()
+
+	dq.Publish("jobs", []byte("1"))
+	dq.Subscribe("#", MessageHandlerFunc(func([]byte) error { return nil }))
+
+	topics, err := dq.listTopics()
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if len(topics) != 1 || topics[0] != "jobs" {
+		t.Fatalf("expect topic jobs only got %v", topics)
+	}
+	entries, err := os.ReadDir(path)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if len(entries) != 2 {
+		t.Fatalf("expect jobs and delayed directory got %d entries", len(entries))
+	}
+}
+
+func TestDiskQueueLegacySegment(t *testing.T) {
+	path := t.TempDir()
+	dir := filepath.Join(path, "events")
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatal(err.Error())
+	}
+	// frames without ttl written before segments are versioned
+	var legacy []byte
+	for _, payload := range []string{"1", "2"} {
+		frame := make([]byte, _legacyHeaderLen+len(payload))
+		binary.BigEndian.PutUint32(frame, uint32(len(payload)))
+		binary.BigEndian.PutUint64(frame[4:], uint64(time.Now().UnixNano()))
+		copy(frame[_legacyHeaderLen:], payload)
+		legacy = append(legacy, frame...)
+	}
+	if err := os.WriteFile(filepath.Join(dir, fmt.Sprintf("%020d.seg", 0)), legacy, 0644); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	dq, err := OpenDiskQueue(path)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer dq.Close()
+
+	got := make(chan string, 4)
+	dq.Subscribe("events", MessageHandlerFunc(func(bts []byte) error {
+		got <- string(bts)
+
+		return nil
+	}))
+	dq.Publish("events", []byte("3"))
+	for _, expect := range []string{"1", "2", "3"} {
+		select {
+		case msg := <-got:
+			if msg != expect {
+				t.Fatalf("expect %s got %s", expect, msg)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("expect %s delivered", expect)
+		}
+	}
+	if _, err = os.Stat(segmentPath(dir, int64(len(legacy)))); err != nil {
+		t.Fatalf("expect new frames appended to versioned segment: %s", err.Error())
+	}
+}
@@ -0,0 +1,195 @@
+/*
+ *   Copyright (c) 2023 CodapeWild
+ *   All rights reserved.
+
+ *   Licensed under the Apache License, Version 2.0 (the "License");
+ *   you may not use this file except in compliance with the License.
+ *   You may obtain a copy of the License at
+
+ *   http://www.apache.org/licenses/LICENSE-2.0
+
+ *   Unless required by applicable law or agreed to in writing, software
+ *   distributed under the License is distributed on an "AS IS" BASIS,
+ *   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *   See the License for the specific language governing permissions and
+ *   limitations under the License.
+ */
+
+package msque
+
+import (
+	"encoding/binary"
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// _delayedDir is not a valid escaped topic since %zz fails to unescape, it is skipped by listTopics as well.
+const _delayedDir = "%zz-delayed"
+
+// diskDelayed is a delayed message saved as <due>-<random> in delayed directory,
+// the file holds topic length in uint16, topic and message frame.
+type diskDelayed struct {
+	due  int64
+	path string
+}
+
+// delay saves msg and schedules it to be appended into topic once due.
+func (dq *DiskQueue) delay(topic string, msg message) error {
+	if len(topic) > math.MaxUint16 {
+		return ErrInvalidTopic
+	}
+	bts := make([]byte, 2+len(topic)+msg.len())
+	binary.BigEndian.PutUint16(bts, uint16(len(topic)))
+	copy(bts[2:], topic)
+	copy(bts[2+len(topic):], msg)
+
+	dir := filepath.Join(dq.path, _delayedDir)
+	f, err := os.CreateTemp(dir, "*.tmp")
+	if err != nil {
+		return err
+	}
+	if _, err = f.Write(bts); err == nil && dq.fsync {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(f.Name())
+
+		return err
+	}
+
+	item := diskDelayed{due: msg.due().UnixNano()}
+	item.path = filepath.Join(dir, fmt.Sprintf("%020d-%s", item.due, strings.TrimSuffix(filepath.Base(f.Name()), ".tmp")))
+	if err = os.Rename(f.Name(), item.path); err != nil {
+		return err
+	}
+
+	dq.Lock()
+	dq.scheduleDelayed(item)
+	dq.Unlock()
+
+	return nil
+}
+
+// scheduleDelayed inserts item in the order of due time, dq must be locked.
+func (dq *DiskQueue) scheduleDelayed(item diskDelayed) {
+	i := sort.Search(len(dq.delayed), func(i int) bool { return dq.delayed[i].due > item.due })
+	dq.delayed = append(dq.delayed, diskDelayed{})
+	copy(dq.delayed[i+1:], dq.delayed[i:])
+	dq.delayed[i] = item
+
+	select {
+	case dq.delayWake <- struct{}{}:
+	default:
+	}
+}
+
+// runDelayed appends the delayed messages into their topics once due, the file is removed
+// after appended so a message may be delivered twice if crashed in between.
+func (dq *DiskQueue) runDelayed() {
+	timer := time.NewTimer(time.Hour)
+	defer timer.Stop()
+
+	for {
+		var wait <-chan time.Time
+		dq.Lock()
+		if len(dq.delayed) > 0 {
+			if d := time.Until(time.Unix(0, dq.delayed[0].due)); d > 0 {
+				timer.Reset(d)
+				wait = timer.C
+			} else {
+				item := dq.delayed[0]
+				dq.delayed = dq.delayed[1:]
+				dq.Unlock()
+				if err := dq.publishDelayed(item); err != nil {
+					log.Println(err.Error())
+				}
+
+				continue
+			}
+		}
+		dq.Unlock()
+
+		select {
+		case <-dq.closer:
+			return
+		case <-dq.delayWake:
+		case <-wait:
+		}
+		if !timer.Stop() {
+			select {
+			case <-timer.C:
+			default:
+			}
+		}
+	}
+}
+
+func (dq *DiskQueue) publishDelayed(item diskDelayed) error {
+	bts, err := os.ReadFile(item.path)
+	if err != nil {
+		return err
+	}
+	if len(bts) < 2 || len(bts) < 2+int(binary.BigEndian.Uint16(bts)) {
+		return fmt.Errorf("broken delayed message %s", item.path)
+	}
+	n := 2 + int(binary.BigEndian.Uint16(bts))
+	topic, msg := string(bts[2:n]), message(bts[n:])
+	if !msg.valid() {
+		return fmt.Errorf("broken delayed message %s", item.path)
+	}
+
+	dq.Lock()
+	dt, err := dq.topic(topic)
+	dq.Unlock()
+	if err != nil {
+		return err
+	}
+	if err = dt.append(msg, dq.segmentSize, dq.fsync); err != nil {
+		return err
+	}
+
+	return os.Remove(item.path)
+}
+
+// loadDelayed restores the delayed messages saved, the temporary files left by crash are removed.
+func (dq *DiskQueue) loadDelayed() error {
+	dir := filepath.Join(dq.path, _delayedDir)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range entries {
+		if strings.HasSuffix(entry.Name(), ".tmp") {
+			if err = os.Remove(filepath.Join(dir, entry.Name())); err != nil {
+				return err
+			}
+
+			continue
+		}
+		due, _, ok := strings.Cut(entry.Name(), "-")
+		if !ok {
+			continue
+		}
+		item := diskDelayed{path: filepath.Join(dir, entry.Name())}
+		if item.due, err = strconv.ParseInt(due, 10, 64); err != nil {
+			return err
+		}
+		dq.scheduleDelayed(item)
+	}
+
+	return nil
+}
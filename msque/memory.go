@@ -0,0 +1,333 @@
+/*
+ *   Copyright (c) 2023 CodapeWild
+ *   All rights reserved.
+
+ *   Licensed under the Apache License, Version 2.0 (the "License");
+ *   you may not use this file except in compliance with the License.
+ *   You may obtain a copy of the License at
+
+ *   http://www.apache.org/licenses/LICENSE-2.0
+
+ *   Unless required by applicable law or agreed to in writing, software
+ *   distributed under the License is distributed on an "AS IS" BASIS,
+ *   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *   See the License for the specific language governing permissions and
+ *   limitations under the License.
+ */
+
+package msque
+
+import (
+	"errors"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var _ MessageQueue = (*MemoryQueue)(nil)
+
+var (
+	ErrQueueClosed = errors.New("message queue closed")
+	ErrBufferFull  = errors.New("subscriber buffer full")
+)
+
+// MemoryQueue is an in-process broker fanning out the messages of a topic to every subscriber
+// whose filter matches, each subscriber has its own bounded buffer and handles messages concurrently.
+type MemoryQueue struct {
+	sync.RWMutex
+	subs           *topicTrie[*subscriber]
+	bufferSize     int           // size of buffer of each subscriber
+	concurrency    int           // number of goroutines handling messages of each subscriber
+	publishTimeout time.Duration // max duration Publish waits for full buffer, wait forever if 0
+	expiredTopic   string        // topic the expired messages published to, dropped if empty
+	wg             sync.WaitGroup
+	closer         chan struct{}
+}
+
+type subscriber struct {
+	handler    MessageHandler
+	retry      RetryPolicy
+	deadLetter string // topic the messages exhausted retries published to, dropped if empty
+	buf        chan delivery
+}
+
+// delivery is a message copy of subscriber along with the topic published to.
+type delivery struct {
+	topic   string
+	msg     message
+	expired *atomic.Bool // shared by the copies of a message so it expires once
+}
+
+type MemoryQueueOption func(mq *MemoryQueue)
+
+// MemQueWithBuffer sets the buffer size of each subscriber, 100 by default.
+func MemQueWithBuffer(size int) MemoryQueueOption {
+	return func(mq *MemoryQueue) {
+		if size > 0 {
+			mq.bufferSize = size
+		}
+	}
+}
+
+// MemQueWithConcurrency sets the number of goroutines handling messages of each subscriber, 1 by default.
+func MemQueWithConcurrency(n int) MemoryQueueOption {
+	return func(mq *MemoryQueue) {
+		if n > 0 {
+			mq.concurrency = n
+		}
+	}
+}
+
+// MemQueWithPublishTimeout makes Publish fail with ErrBufferFull if a subscriber buffer
+// is still full after d.
+func MemQueWithPublishTimeout(d time.Duration) MemoryQueueOption {
+	return func(mq *MemoryQueue) {
+		mq.publishTimeout = d
+	}
+}
+
+// MemQueWithExpiredTopic publishes the messages expired by TTL to topic as DeadLetter instead of dropping them,
+// a message expires once no matter how many subscribers it is fanned out to.
+func MemQueWithExpiredTopic(topic string) MemoryQueueOption {
+	return func(mq *MemoryQueue) {
+		mq.expiredTopic = topic
+	}
+}
+
+// Publish fans message out to all the subscribers matching topic, it blocks while any buffer is full.
+// The delayed message is kept in memory and fanned out to the subscribers matching once due, it is
+// lost if queue closed before.
+func (mq *MemoryQueue) Publish(topic string, bts []byte, opts ...PublishOption) error {
+	if err := validTopic(topic); err != nil {
+		return err
+	}
+	msg, err := newMessage(bts, opts...)
+	if err != nil {
+		return err
+	}
+
+	if d := time.Until(msg.due()); d > 0 {
+		time.AfterFunc(d, func() {
+			if err := mq.fanOut(topic, msg); err != nil && !errors.Is(err, ErrQueueClosed) {
+				log.Println(err.Error())
+			}
+		})
+
+		return nil
+	}
+
+	return mq.fanOut(topic, msg)
+}
+
+func (mq *MemoryQueue) fanOut(topic string, msg message) error {
+	select {
+	case <-mq.closer:
+		return ErrQueueClosed
+	default:
+	}
+
+	mq.RLock()
+	subs := mq.subs.match(topic)
+	mq.RUnlock()
+
+	var (
+		errs    []error
+		expired = &atomic.Bool{}
+	)
+	for _, sub := range subs {
+		// every subscriber owns a copy since retry counter is changed in place
+		if err := mq.deliver(sub, delivery{topic: topic, msg: append(message(nil), msg...), expired: expired}); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+func (mq *MemoryQueue) deliver(sub *subscriber, d delivery) error {
+	var timeout <-chan time.Time
+	if mq.publishTimeout > 0 {
+		timer := time.NewTimer(mq.publishTimeout)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+
+	select {
+	case <-mq.closer:
+		return ErrQueueClosed
+	case <-timeout:
+		return ErrBufferFull
+	case sub.buf <- d:
+		return nil
+	}
+}
+
+// Subscribe registers handler for topic filter, the failed messages are requeued until MaxRetry
+// reached if no retry policy given.
+func (mq *MemoryQueue) Subscribe(filter string, handler MessageHandler, opts ...SubscribeOption) {
+	if err := validFilter(filter); err != nil {
+		log.Println(err.Error())
+
+		return
+	}
+	select {
+	case <-mq.closer:
+		return
+	default:
+	}
+
+	so := &subscribeOptions{retry: RetryWithInterval(MaxRetry, 0)}
+	for _, opt := range opts {
+		opt(so)
+	}
+	sub := &subscriber{
+		handler:    handler,
+		retry:      so.retry,
+		deadLetter: so.deadLetter,
+		buf:        make(chan delivery, mq.bufferSize),
+	}
+	mq.Lock()
+	mq.subs.insert(filter, sub)
+	mq.Unlock()
+
+	for i := 0; i < mq.concurrency; i++ {
+		mq.wg.Add(1)
+		go func() {
+			defer mq.wg.Done()
+			mq.handleLoop(sub)
+		}()
+	}
+}
+
+func (mq *MemoryQueue) handleLoop(sub *subscriber) {
+	for {
+		select {
+		case <-mq.closer:
+			return
+		case d := <-sub.buf:
+			mq.handle(sub, d)
+		}
+	}
+}
+
+func (mq *MemoryQueue) handle(sub *subscriber, d delivery) {
+	_, _, _, payload, err := d.msg.parse()
+	if err != nil {
+		log.Println(err.Error())
+
+		return
+	}
+	if d.msg.expired(time.Now()) {
+		if d.expired.CompareAndSwap(false, true) {
+			mq.expire(d.topic, d.msg, payload)
+		}
+
+		return
+	}
+	if err = sub.handler.HandleMessage(payload); err == nil {
+		return
+	}
+
+	times, ok := d.msg.failOnce()
+	if sub.retry.exhausted(times, ok) {
+		mq.deadLetter(sub, d.topic, payload, int(times), err)
+
+		return
+	}
+	// requeue without blocking the handling goroutine which may be the only one draining buffer
+	backoff := sub.retry.backoff(int(times))
+	if backoff <= 0 {
+		select {
+		case sub.buf <- d:
+			return
+		default:
+		}
+	}
+	mq.wg.Add(1)
+	go func() {
+		defer mq.wg.Done()
+		if backoff > 0 {
+			timer := time.NewTimer(backoff)
+			defer timer.Stop()
+			select {
+			case <-mq.closer:
+				return
+			case <-timer.C:
+			}
+		}
+		select {
+		case <-mq.closer:
+		case sub.buf <- d:
+		}
+	}()
+}
+
+// deadLetter publishes the message exhausted its retries to the dead-letter topic of subscription.
+func (mq *MemoryQueue) deadLetter(sub *subscriber, topic string, payload []byte, attempts int, reason error) {
+	if sub.deadLetter == "" {
+		log.Printf("drop message after %d attempts: %s", attempts, reason.Error())
+
+		return
+	}
+
+	dl := &DeadLetter{Topic: topic, Reason: reason.Error(), Attempts: attempts, FailedAt: time.Now(), Payload: payload}
+	bts, err := dl.Encode()
+	if err != nil {
+		log.Println(err.Error())
+
+		return
+	}
+	mq.republish(sub.deadLetter, bts)
+}
+
+// republish publishes payload to topic without blocking the handling goroutine.
+func (mq *MemoryQueue) expire(topic string, msg message, payload []byte) {
+	if mq.expiredTopic == "" {
+		log.Println("drop expired message")
+
+		return
+	}
+
+	bts, err := expiredLetter(topic, msg, payload)
+	if err != nil {
+		log.Println(err.Error())
+
+		return
+	}
+	mq.republish(mq.expiredTopic, bts)
+}
+
+func (mq *MemoryQueue) republish(topic string, payload []byte) {
+	mq.wg.Add(1)
+	go func() {
+		defer mq.wg.Done()
+		if err := mq.Publish(topic, payload); err != nil && !errors.Is(err, ErrQueueClosed) {
+			log.Println(err.Error())
+		}
+	}()
+}
+
+// Close stops handling and waits for the handlers running to return, messages buffered are dropped.
+func (mq *MemoryQueue) Close() {
+	select {
+	case <-mq.closer:
+	default:
+		close(mq.closer)
+	}
+	mq.wg.Wait()
+}
+
+func NewMemoryQueue(opts ...MemoryQueueOption) *MemoryQueue {
+	mq := &MemoryQueue{
+		subs:        newTopicTrie[*subscriber](),
+		bufferSize:  100,
+		concurrency: 1,
+		closer:      make(chan struct{}),
+	}
+	for _, opt := range opts {
+		opt(mq)
+	}
+
+	return mq
+}
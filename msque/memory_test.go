@@ -0,0 +1,226 @@
+/*
+ *   Copyright (c) 2023 CodapeWild
+ *   All rights reserved.
+
+ *   Licensed under the Apache License, Version 2.0 (the "License");
+ *   you may not use this file except in compliance with the License.
+ *   You may obtain a copy of the License at
+
+ *   http://www.apache.org/licenses/LICENSE-2.0
+
+ *   Unless required by applicable law or agreed to in writing, software
+ *   distributed under the License is distributed on an "AS IS" BASIS,
+ *   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *   See the License for the specific language governing permissions and
+ *   limitations under the License.
+ */
+
+package msque
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestMemoryQueueFanOut(t *testing.T) {
+	mq := NewMemoryQueue(MemQueWithBuffer(10), MemQueWithConcurrency(2))
+	defer mq.Close()
+
+	var (
+		received = make(chan string, 10)
+		failed   = make(chan struct{}, 1)
+	)
+	for _, name := range []string{"a", "b"} {
+		mq.Subscribe("orders", MessageHandlerFunc(func(bts []byte) error {
+			received <- name + ":" + string(bts)
+
+			return nil
+		}))
+	}
+	// fails once then succeeds on retry
+	mq.Subscribe("orders", MessageHandlerFunc(func(bts []byte) error {
+		select {
+		case failed <- struct{}{}:
+			return errors.New("failed")
+		default:
+		}
+		received <- "c:" + string(bts)
+
+		return nil
+	}))
+
+	if err := mq.Publish("orders", []byte("1")); err != nil {
+		t.Fatal(err.Error())
+	}
+	if err := mq.Publish("others", []byte("2")); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	got := make(map[string]bool)
+	for i := 0; i < 3; i++ {
+		select {
+		case msg := <-received:
+			got[msg] = true
+		case <-time.After(time.Second):
+			t.Fatalf("expect 3 messages got %v", got)
+		}
+	}
+	if !got["a:1"] || !got["b:1"] || !got["c:1"] {
+		t.Fatalf("unexpected messages %v", got)
+	}
+}
+
+func TestMemoryQueueBufferFull(t *testing.T) {
+	mq := NewMemoryQueue(MemQueWithBuffer(1), MemQueWithPublishTimeout(10*time.Millisecond))
+	defer mq.Close()
+
+	release := make(chan struct{})
+	mq.Subscribe("slow", MessageHandlerFunc(func(bts []byte) error {
+		<-release
+
+		return nil
+	}))
+
+	// one message in handler and one in buffer
+	mq.Publish("slow", []byte("1"))
+	time.Sleep(10 * time.Millisecond)
+	mq.Publish("slow", []byte("2"))
+	if err := mq.Publish("slow", []byte("3")); !errors.Is(err, ErrBufferFull) {
+		t.Fatalf("expect ErrBufferFull got %v", err)
+	}
+	close(release)
+}
+
+func TestMemoryQueueWildcard(t *testing.T) {
+	mq := NewMemoryQueue()
+	defer mq.Close()
+
+	received := make(chan string, 4)
+	for _, filter := range []string{"devices/+/telemetry", "devices/#"} {
+		mq.Subscribe(filter, MessageHandlerFunc(func(bts []byte) error {
+			received <- filter + ":" + string(bts)
+
+			return nil
+		}))
+	}
+	mq.Publish("devices/1/telemetry", []byte("1"))
+	mq.Publish("devices", []byte("2"))
+
+	got := make(map[string]bool)
+	for i := 0; i < 3; i++ {
+		select {
+		case msg := <-received:
+			got[msg] = true
+		case <-time.After(time.Second):
+			t.Fatalf("expect 3 messages got %v", got)
+		}
+	}
+	if !got["devices/+/telemetry:1"] || !got["devices/#:1"] || !got["devices/#:2"] {
+		t.Fatalf("unexpected messages %v", got)
+	}
+}
+
+func TestMemoryQueueDelayAndTTL(t *testing.T) {
+	mq := NewMemoryQueue(MemQueWithExpiredTopic("expired"))
+	defer mq.Close()
+
+	received := make(chan string, 4)
+	for _, topic := range []string{"jobs", "jobs", "expired"} {
+		mq.Subscribe(topic, MessageHandlerFunc(func(bts []byte) error {
+			if topic == "expired" {
+				dl := &DeadLetter{}
+				if err := dl.Decode(bts); err != nil {
+					return err
+				}
+				bts = []byte(dl.Topic + ":" + dl.Reason + ":" + string(dl.Payload))
+			}
+			received <- topic + ":" + string(bts)
+
+			return nil
+		}))
+	}
+
+	start := time.Now()
+	mq.Publish("jobs", []byte("delayed"), PublishWithDelay(30*time.Millisecond))
+	mq.Publish("jobs", []byte("stale"), PublishWithTTL(time.Nanosecond))
+	// both subscribers of jobs see the stale message but it expires once
+	for _, expect := range []string{"expired:jobs:expired:stale", "jobs:delayed", "jobs:delayed"} {
+		select {
+		case msg := <-received:
+			if msg != expect {
+				t.Fatalf("expect %s got %s", expect, msg)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("expect %s delivered", expect)
+		}
+	}
+	select {
+	case msg := <-received:
+		t.Fatalf("unexpected message %s", msg)
+	case <-time.After(20 * time.Millisecond):
+	}
+	if time.Since(start) < 30*time.Millisecond {
+		t.Fatal("expect delayed message delivered once due")
+	}
+}
+
+func TestMemoryQueueDeadLetter(t *testing.T) {
+	mq := NewMemoryQueue()
+	defer mq.Close()
+
+	var (
+		attempts    int
+		deadLetters = make(chan *DeadLetter, 1)
+	)
+	mq.Subscribe("orders/+", MessageHandlerFunc(func(bts []byte) error {
+		attempts++
+
+		return errors.New("poison")
+	}), SubscribeWithRetry(RetryWithBackoff(3, time.Millisecond, 2*time.Millisecond)), SubscribeWithDeadLetter("dlq"))
+	mq.Subscribe("dlq", MessageHandlerFunc(func(bts []byte) error {
+		dl := &DeadLetter{}
+		if err := dl.Decode(bts); err != nil {
+			return err
+		}
+		deadLetters <- dl
+
+		return nil
+	}))
+	mq.Publish("orders/1", []byte("1"))
+
+	select {
+	case dl := <-deadLetters:
+		if dl.Topic != "orders/1" || dl.Reason != "poison" || dl.Attempts != 3 || string(dl.Payload) != "1" {
+			t.Fatalf("unexpected dead letter %+v", dl)
+		}
+		if attempts != 3 {
+			t.Fatalf("expect 3 attempts got %d", attempts)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expect dead letter published")
+	}
+}
+
+func TestMemoryQueueEmptyPayload(t *testing.T) {
+	mq := NewMemoryQueue()
+	defer mq.Close()
+
+	received := make(chan []byte, 1)
+	mq.Subscribe("empty", MessageHandlerFunc(func(bts []byte) error {
+		received <- bts
+
+		return nil
+	}))
+	if err := mq.Publish("empty", []byte{}); err != nil {
+		t.Fatal(err.Error())
+	}
+	select {
+	case bts := <-received:
+		if len(bts) != 0 {
+			t.Fatalf("expect empty payload got %v", bts)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expect empty message delivered")
+	}
+}
@@ -30,29 +30,80 @@ type MessageHandler interface {
 type MessageHandlerFunc func(bts []byte) (err error)
 
 func (h MessageHandlerFunc) HandleMessage(bts []byte) (err error) {
-	return h.HandleMessage(bts)
+	return h(bts)
 }
 
+// MessageQueue publishes to topics separated by / and subscribes by MQTT-style filters,
+// + matches one level and # matches all the levels left.
 type MessageQueue interface {
-	Publish(topic string, bts []byte) (err error)
-	Subscribe(topic string, handler MessageHandler)
+	Publish(topic string, bts []byte, opts ...PublishOption) (err error)
+	Subscribe(filter string, handler MessageHandler, opts ...SubscribeOption)
+}
+
+type subscribeOptions struct {
+	retry      RetryPolicy
+	deadLetter string
+}
+
+type SubscribeOption func(so *subscribeOptions)
+
+// SubscribeWithRetry retries the messages failed to handle by policy.
+func SubscribeWithRetry(policy RetryPolicy) SubscribeOption {
+	return func(so *subscribeOptions) {
+		so.retry = policy
+	}
+}
+
+// SubscribeWithDeadLetter publishes a DeadLetter to topic once message exhausts its retries instead of dropping it,
+// topic should not be matched by the subscription itself or failed dead letters are wrapped over and over.
+func SubscribeWithDeadLetter(topic string) SubscribeOption {
+	return func(so *subscribeOptions) {
+		so.deadLetter = topic
+	}
+}
+
+type publishOptions struct {
+	delay time.Duration
+	ttl   time.Duration
+}
+
+type PublishOption func(po *publishOptions)
+
+// PublishWithDelay delivers message once d passed since publishing.
+func PublishWithDelay(d time.Duration) PublishOption {
+	return func(po *publishOptions) {
+		po.delay = d
+	}
+}
+
+// PublishWithTTL expires message not handled successfully within d since it is due for delivery,
+// the expired message is dropped or published once to the expired topic of queue as DeadLetter.
+func PublishWithTTL(d time.Duration) PublishOption {
+	return func(po *publishOptions) {
+		po.ttl = d
+	}
 }
 
 /*
 	Memory model in message
-| len            | ts                | retry         | payload      |
-| -------------- | ----------------- | ------------- | ------------ |
-| payload length | enqueue timestamp | enqueue times | message body |
-| uint32         | int64             | uint8         | []byte       |
-| 4294967295     | -                 | 255           | 4095MB       |
+| len            | ts                | retry         | ttl                | payload      |
+| -------------- | ----------------- | ------------- | ------------------ | ------------ |
+| payload length | enqueue timestamp | enqueue times | time-to-live in ns | message body |
+| uint32         | int64             | uint8         | int64              | []byte       |
+| 4294967295     | -                 | 255           | 0 never expires    | 4095MB       |
+	ts of delayed message is the time it is due for delivery.
 */
 const (
 	MaxLen    = 1<<32 - 1
 	MaxRetry  = 1<<8 - 1
-	HeaderLen = 4 + 8 + 1
+	HeaderLen = 4 + 8 + 1 + 8
 )
 
-func newMessage(payload []byte) (message, error) {
+// _legacyHeaderLen is the header length of frames without ttl, DiskQueue segments written before
+// ttl added are unversioned and their frames are upgraded on read.
+const _legacyHeaderLen = 4 + 8 + 1
+
+func newMessage(payload []byte, opts ...PublishOption) (message, error) {
 	pl := len(payload)
 	if pl > MaxLen {
 		return nil, errors.New("max message size overflow")
@@ -60,7 +111,12 @@ func newMessage(payload []byte) (message, error) {
 
 	bts := make([]byte, HeaderLen+pl)
 	binary.BigEndian.PutUint32(bts, uint32(pl))
-	binary.BigEndian.PutUint64(bts[4:], uint64(time.Now().UnixNano()))
+	po := &publishOptions{}
+	for _, opt := range opts {
+		opt(po)
+	}
+	binary.BigEndian.PutUint64(bts[4:], uint64(time.Now().Add(po.delay).UnixNano()))
+	binary.BigEndian.PutUint64(bts[13:], uint64(po.ttl))
 	copy(bts[HeaderLen:], payload)
 
 	return bts, nil
@@ -73,7 +129,7 @@ func (ms message) len() int {
 }
 
 func (ms message) valid() bool {
-	return len(ms) > HeaderLen && (binary.BigEndian.Uint32(ms) == uint32(len(ms)-HeaderLen))
+	return len(ms) >= HeaderLen && (binary.BigEndian.Uint32(ms) == uint32(len(ms)-HeaderLen))
 }
 
 func (ms message) parse() (n uint32, ts time.Duration, retry uint8, payload []byte, err error) {
@@ -91,6 +147,31 @@ func (ms message) parse() (n uint32, ts time.Duration, retry uint8, payload []by
 	return
 }
 
+// upgradeLegacy converts the frame without ttl into current layout never expiring,
+// ts of legacy frame is enqueue timestamp which is also the due time.
+func upgradeLegacy(frame []byte) message {
+	ms := make(message, len(frame)+HeaderLen-_legacyHeaderLen)
+	copy(ms, frame[:_legacyHeaderLen])
+	copy(ms[HeaderLen:], frame[_legacyHeaderLen:])
+
+	return ms
+}
+
+// due returns the time message is deliverable.
+func (ms message) due() time.Time {
+	return time.Unix(0, int64(binary.BigEndian.Uint64(ms[4:])))
+}
+
+func (ms message) ttl() time.Duration {
+	return time.Duration(binary.BigEndian.Uint64(ms[13:]))
+}
+
+func (ms message) expired(now time.Time) bool {
+	ttl := ms.ttl()
+
+	return ttl > 0 && now.Sub(ms.due()) > ttl
+}
+
 func (ms message) failOnce() (times uint8, ok bool) {
 	times = ms[12] + 1
 	ms[12] = times
@@ -0,0 +1,101 @@
+/*
+ *   Copyright (c) 2023 CodapeWild
+ *   All rights reserved.
+
+ *   Licensed under the Apache License, Version 2.0 (the "License");
+ *   you may not use this file except in compliance with the License.
+ *   You may obtain a copy of the License at
+
+ *   http://www.apache.org/licenses/LICENSE-2.0
+
+ *   Unless required by applicable law or agreed to in writing, software
+ *   distributed under the License is distributed on an "AS IS" BASIS,
+ *   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *   See the License for the specific language governing permissions and
+ *   limitations under the License.
+ */
+
+package msque
+
+import (
+	"encoding/json"
+	"math"
+	"math/rand"
+	"time"
+)
+
+// RetryPolicy decides how many times a subscription handles a failed message and how long it
+// waits in between.
+type RetryPolicy struct {
+	maxAttempts int                       // max number of handling including the first one
+	backoff     func(n int) time.Duration // duration to wait before the nth retry, starting from 1
+}
+
+// RetryWithInterval handles message at most maxAttempts times waiting d before every retry.
+func RetryWithInterval(maxAttempts int, d time.Duration) RetryPolicy {
+	return RetryWithBackoffFunc(maxAttempts, func(int) time.Duration { return d })
+}
+
+// RetryWithBackoff gives a message up to maxAttempts handlings, the wait before retry n is initial
+// times 2^(n-1), capped by max or unbounded if max is 0. Every wait is shortened randomly by at most
+// half so the subscribers failing on the same message do not hit the downstream together again.
+func RetryWithBackoff(maxAttempts int, initial, max time.Duration) RetryPolicy {
+	if initial <= 0 {
+		initial = 100 * time.Millisecond
+	}
+
+	return RetryWithBackoffFunc(maxAttempts, func(n int) time.Duration {
+		d := initial
+		for ; n > 1 && (max == 0 || d < max) && d <= math.MaxInt64/2; n-- {
+			d *= 2
+		}
+		if max > 0 {
+			d = min(d, max)
+		}
+
+		return d - time.Duration(rand.Int63n(int64(d/2)+1))
+	})
+}
+
+// RetryWithBackoffFunc handles message at most maxAttempts times waiting backoff(n) before the nth retry,
+// maxAttempts is limited to MaxRetry since the retry counter of message is a byte.
+func RetryWithBackoffFunc(maxAttempts int, backoff func(n int) time.Duration) RetryPolicy {
+	if maxAttempts <= 0 {
+		maxAttempts = 1
+	}
+
+	return RetryPolicy{maxAttempts: min(maxAttempts, MaxRetry), backoff: backoff}
+}
+
+// exhausted reports whether no retry left after failed times.
+func (rp RetryPolicy) exhausted(failed uint8, ok bool) bool {
+	return !ok || int(failed) >= rp.maxAttempts
+}
+
+// ReasonExpired is the Reason of DeadLetter published to the expired topic of queue.
+const ReasonExpired = "expired"
+
+// DeadLetter is published to the dead-letter topic of subscription once a message exhausts its retries,
+// or to the expired topic of queue once a message expires by TTL.
+type DeadLetter struct {
+	Topic    string    `json:"topic"`
+	Reason   string    `json:"reason"` // error returned by the last handling or ReasonExpired
+	Attempts int       `json:"attempts"`
+	FailedAt time.Time `json:"failed_at"`
+	Payload  []byte    `json:"payload"`
+}
+
+func (dl *DeadLetter) Encode() ([]byte, error) {
+	return json.Marshal(dl)
+}
+
+func (dl *DeadLetter) Decode(bts []byte) error {
+	return json.Unmarshal(bts, dl)
+}
+
+// expiredLetter wraps message of topic expired by TTL, FailedAt is the time it expired.
+func expiredLetter(topic string, msg message, payload []byte) ([]byte, error) {
+	dl := &DeadLetter{Topic: topic, Reason: ReasonExpired, FailedAt: msg.due().Add(msg.ttl()), Payload: payload}
+
+	return dl.Encode()
+}
@@ -0,0 +1,42 @@
+/*
+ *   Copyright (c) 2023 CodapeWild
+ *   All rights reserved.
+
+ *   Licensed under the Apache License, Version 2.0 (the "License");
+ *   you may not use this file except in compliance with the License.
+ *   You may obtain a copy of the License at
+
+ *   http://www.apache.org/licenses/LICENSE-2.0
+
+ *   Unless required by applicable law or agreed to in writing, software
+ *   distributed under the License is distributed on an "AS IS" BASIS,
+ *   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *   See the License for the specific language governing permissions and
+ *   limitations under the License.
+ */
+
+package msque
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRetryWithBackoff(t *testing.T) {
+	rp := RetryWithBackoff(MaxRetry, 100*time.Millisecond, 0)
+	for n := 1; n < MaxRetry; n++ {
+		if d := rp.backoff(n); d <= 0 {
+			t.Fatalf("expect positive backoff for retry %d got %s", n, d)
+		}
+	}
+
+	rp = RetryWithBackoff(MaxRetry, 100*time.Millisecond, time.Second)
+	for n := 1; n < MaxRetry; n++ {
+		if d := rp.backoff(n); d <= 0 || d > time.Second {
+			t.Fatalf("expect backoff for retry %d within max got %s", n, d)
+		}
+	}
+	if rp.maxAttempts != MaxRetry || RetryWithBackoff(1000, 0, 0).maxAttempts != MaxRetry {
+		t.Fatalf("expect max attempts limited to %d", MaxRetry)
+	}
+}
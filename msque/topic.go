@@ -0,0 +1,147 @@
+/*
+ *   Copyright (c) 2023 CodapeWild
+ *   All rights reserved.
+
+ *   Licensed under the Apache License, Version 2.0 (the "License");
+ *   you may not use this file except in compliance with the License.
+ *   You may obtain a copy of the License at
+
+ *   http://www.apache.org/licenses/LICENSE-2.0
+
+ *   Unless required by applicable law or agreed to in writing, software
+ *   distributed under the License is distributed on an "AS IS" BASIS,
+ *   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *   See the License for the specific language governing permissions and
+ *   limitations under the License.
+ */
+
+package msque
+
+import (
+	"errors"
+	"slices"
+	"strings"
+)
+
+var ErrInvalidTopic = errors.New("invalid topic")
+
+const (
+	_topicSeparator   = "/"
+	_singleLevelMatch = "+"
+	_multiLevelMatch  = "#"
+)
+
+// validTopic checks the topic published to, wildcards are only allowed in filters subscribed.
+func validTopic(topic string) error {
+	if topic == "" || strings.ContainsAny(topic, _singleLevelMatch+_multiLevelMatch) {
+		return ErrInvalidTopic
+	}
+
+	return nil
+}
+
+// validFilter checks the MQTT-style topic filter, + matches exactly one level and
+// # matches any number of levels and must be the last level.
+func validFilter(filter string) error {
+	if filter == "" {
+		return ErrInvalidTopic
+	}
+	levels := strings.Split(filter, _topicSeparator)
+	for i, level := range levels {
+		switch {
+		case level == _singleLevelMatch:
+		case level == _multiLevelMatch:
+			if i != len(levels)-1 {
+				return ErrInvalidTopic
+			}
+		case strings.ContainsAny(level, _singleLevelMatch+_multiLevelMatch):
+			return ErrInvalidTopic
+		}
+	}
+
+	return nil
+}
+
+// matchFilter reports whether topic matches filter.
+func matchFilter(filter, topic string) bool {
+	filters, levels := strings.Split(filter, _topicSeparator), strings.Split(topic, _topicSeparator)
+	for i, level := range filters {
+		if level == _multiLevelMatch {
+			return true
+		}
+		if i >= len(levels) || (level != _singleLevelMatch && level != levels[i]) {
+			return false
+		}
+	}
+
+	return len(filters) == len(levels)
+}
+
+func isWildcard(filter string) bool {
+	return strings.ContainsAny(filter, _singleLevelMatch+_multiLevelMatch)
+}
+
+// topicTrie indexes the values subscribed by topic filter level by level, matching a topic
+// walks at most three branches per level no matter how many filters inserted.
+type topicTrie[T any] struct {
+	root *trieNode[T]
+}
+
+type trieNode[T any] struct {
+	children map[string]*trieNode[T]
+	values   []T
+}
+
+func (tt *topicTrie[T]) insert(filter string, value T) {
+	node := tt.root
+	for _, level := range strings.Split(filter, _topicSeparator) {
+		child, ok := node.children[level]
+		if !ok {
+			child = &trieNode[T]{children: make(map[string]*trieNode[T])}
+			node.children[level] = child
+		}
+		node = child
+	}
+	node.values = append(node.values, value)
+}
+
+// remove deletes the values of filter that drop reports true.
+func (tt *topicTrie[T]) remove(filter string, drop func(T) bool) {
+	node := tt.root
+	for _, level := range strings.Split(filter, _topicSeparator) {
+		child, ok := node.children[level]
+		if !ok {
+			return
+		}
+		node = child
+	}
+	node.values = slices.DeleteFunc(node.values, drop)
+}
+
+// match returns the values of all the filters matching topic.
+func (tt *topicTrie[T]) match(topic string) []T {
+	return tt.root.match(strings.Split(topic, _topicSeparator), nil)
+}
+
+func (node *trieNode[T]) match(levels []string, values []T) []T {
+	// # also matches the parent level, devices/# matches devices
+	if multi, ok := node.children[_multiLevelMatch]; ok {
+		values = append(values, multi.values...)
+	}
+	if len(levels) == 0 {
+		return append(values, node.values...)
+	}
+
+	if child, ok := node.children[levels[0]]; ok {
+		values = child.match(levels[1:], values)
+	}
+	if single, ok := node.children[_singleLevelMatch]; ok {
+		values = single.match(levels[1:], values)
+	}
+
+	return values
+}
+
+func newTopicTrie[T any]() *topicTrie[T] {
+	return &topicTrie[T]{root: &trieNode[T]{children: make(map[string]*trieNode[T])}}
+}
@@ -0,0 +1,69 @@
+/*
+ *   Copyright (c) 2023 CodapeWild
+ *   All rights reserved.
+
+ *   Licensed under the Apache License, Version 2.0 (the "License");
+ *   you may not use this file except in compliance with the License.
+ *   You may obtain a copy of the License at
+
+ *   http://www.apache.org/licenses/LICENSE-2.0
+
+ *   Unless required by applicable law or agreed to in writing, software
+ *   distributed under the License is distributed on an "AS IS" BASIS,
+ *   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *   See the License for the specific language governing permissions and
+ *   limitations under the License.
+ */
+
+package msque
+
+import (
+	"errors"
+	"slices"
+	"testing"
+)
+
+func TestTopicTrieMatch(t *testing.T) {
+	tt := newTopicTrie[string]()
+	for _, filter := range []string{"devices/+/telemetry", "devices/#", "devices/1/telemetry", "#", "+/+", "other"} {
+		if err := validFilter(filter); err != nil {
+			t.Fatalf("expect %s valid got %s", filter, err.Error())
+		}
+		tt.insert(filter, filter)
+	}
+
+	cases := map[string][]string{
+		"devices/1/telemetry": {"#", "devices/#", "devices/1/telemetry", "devices/+/telemetry"},
+		"devices/2/telemetry": {"#", "devices/#", "devices/+/telemetry"},
+		"devices/1":           {"#", "+/+", "devices/#"},
+		"devices":             {"#", "devices/#"},
+		"other":               {"#", "other"},
+		"other/1/2":           {"#"},
+	}
+	for topic, expect := range cases {
+		got := tt.match(topic)
+		slices.Sort(got)
+		slices.Sort(expect)
+		if !slices.Equal(got, expect) {
+			t.Fatalf("topic %s expect %v got %v", topic, expect, got)
+		}
+		for _, filter := range expect {
+			if !matchFilter(filter, topic) {
+				t.Fatalf("expect %s matches %s", filter, topic)
+			}
+		}
+	}
+}
+
+func TestInvalidTopic(t *testing.T) {
+	for _, filter := range []string{"", "devices/#/telemetry", "devices/a+", "devices#"} {
+		if err := validFilter(filter); !errors.Is(err, ErrInvalidTopic) {
+			t.Fatalf("expect %q invalid filter got %v", filter, err)
+		}
+	}
+	for _, topic := range []string{"", "devices/+", "devices/#"} {
+		if err := validTopic(topic); !errors.Is(err, ErrInvalidTopic) {
+			t.Fatalf("expect %q invalid topic got %v", topic, err)
+		}
+	}
+}
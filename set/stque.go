@@ -22,9 +22,10 @@ var _ Queue = (*SingleThreadQueue)(nil)
 type queopt byte
 
 const (
-	que_peek queopt = 101
-	que_push queopt = 102
-	que_pop  queopt = 103
+	que_peek  queopt = 101
+	que_push  queopt = 102
+	que_pop   queopt = 103
+	que_popif queopt = 104
 )
 
 type stqOptWrapper struct {
@@ -59,6 +60,14 @@ func (stq *SingleThreadQueue) Pop() (any, error) {
 	return <-out, nil
 }
 
+// PopIf pops the head of queue only if cond reports true for it, nil returned otherwise.
+func (stq *SingleThreadQueue) PopIf(cond func(value any) bool) (any, error) {
+	out := make(chan any)
+	stq.opts <- &stqOptWrapper{opt: que_popif, value: cond, out: out}
+
+	return <-out, nil
+}
+
 func (stq *SingleThreadQueue) Close() {
 	select {
 	case <-stq.closer:
@@ -107,6 +116,13 @@ func (stq *SingleThreadQueue) thread(wrapper *stqOptWrapper) {
 		} else {
 			wrapper.out <- nil
 		}
+	case que_popif:
+		if len(stq.que) != 0 && wrapper.value.(func(any) bool)(stq.que[0]) {
+			wrapper.out <- stq.que[0]
+			stq.que = stq.que[1:]
+		} else {
+			wrapper.out <- nil
+		}
 	}
 }
 
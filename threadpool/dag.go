@@ -0,0 +1,275 @@
+/*
+ *   Copyright (c) 2023 CodapeWild
+ *   All rights reserved.
+
+ *   Licensed under the Apache License, Version 2.0 (the "License");
+ *   you may not use this file except in compliance with the License.
+ *   You may obtain a copy of the License at
+
+ *   http://www.apache.org/licenses/LICENSE-2.0
+
+ *   Unless required by applicable law or agreed to in writing, software
+ *   distributed under the License is distributed on an "AS IS" BASIS,
+ *   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *   See the License for the specific language governing permissions and
+ *   limitations under the License.
+ */
+
+package threadpool
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+var (
+	ErrDAGDuplicateNode = errors.New("duplicate dag node")
+	ErrDAGUnknownNode   = errors.New("unknown dag node")
+	ErrDAGCycle         = errors.New("dag has cycle")
+	ErrDAGSkipped       = errors.New("dag node skipped")
+)
+
+// DAGProcess processes a node with the outputs of its dependencies keyed by node name.
+type DAGProcess func(ctx context.Context, inputs map[string]interface{}) (interface{}, error)
+
+type dagInputsKey struct{}
+
+// DAGInputs returns the outputs of dependencies for the job added by DAG.AddJob.
+func DAGInputs(ctx context.Context) map[string]interface{} {
+	inputs, _ := ctx.Value(dagInputsKey{}).(map[string]interface{})
+
+	return inputs
+}
+
+type dagNode struct {
+	name string
+	deps []string
+	proc DAGProcess
+}
+
+// DAGResult is the result of a node, Err is ErrDAGSkipped if the node never ran.
+type DAGResult struct {
+	Output   interface{}
+	Err      error
+	Duration time.Duration
+}
+
+// DAGReport is the results of all nodes keyed by node name.
+type DAGReport map[string]*DAGResult
+
+// DAG runs jobs on WorkerPool as soon as the jobs they depend on finished.
+type DAG struct {
+	nodes           map[string]*dagNode
+	order           []string // nodes in the order added
+	continueOnError bool
+}
+
+type DAGOption func(dag *DAG)
+
+// DAGContinueOnError keeps running the nodes not depending on the failed ones,
+// by default the run stops at the first failure.
+func DAGContinueOnError() DAGOption {
+	return func(dag *DAG) {
+		dag.continueOnError = true
+	}
+}
+
+// Add registers node name processed by proc after all deps finished.
+func (dag *DAG) Add(name string, proc DAGProcess, deps ...string) error {
+	if _, ok := dag.nodes[name]; ok {
+		return fmt.Errorf("%w: %s", ErrDAGDuplicateNode, name)
+	}
+	dag.nodes[name] = &dagNode{name: name, deps: deps, proc: proc}
+	dag.order = append(dag.order, name)
+
+	return nil
+}
+
+// AddJob registers job as node name, the outputs of deps are got by DAGInputs from the context
+// passed to Process and the output sent into out is passed to dependents.
+func (dag *DAG) AddJob(name string, job Job, deps ...string) error {
+	return dag.Add(name, func(ctx context.Context, inputs map[string]interface{}) (interface{}, error) {
+		out := make(chan interface{}, 1)
+		err := job.Process(context.WithValue(ctx, dagInputsKey{}, inputs), out)
+
+		var o interface{}
+		select {
+		case o = <-out:
+		default:
+		}
+		job.Callback(o, err)
+
+		return o, err
+	}, deps...)
+}
+
+// validate checks dependencies exist and there is no cycle.
+func (dag *DAG) validate() error {
+	indegree := make(map[string]int, len(dag.nodes))
+	for _, name := range dag.order {
+		for _, dep := range dag.nodes[name].deps {
+			if _, ok := dag.nodes[dep]; !ok {
+				return fmt.Errorf("%w: %s depends on %s", ErrDAGUnknownNode, name, dep)
+			}
+		}
+		indegree[name] = len(dag.nodes[name].deps)
+	}
+
+	var ready []string
+	for _, name := range dag.order {
+		if indegree[name] == 0 {
+			ready = append(ready, name)
+		}
+	}
+	visited := 0
+	for len(ready) != 0 {
+		name := ready[0]
+		ready = ready[1:]
+		visited++
+		for _, dependent := range dag.dependents(name) {
+			if indegree[dependent]--; indegree[dependent] == 0 {
+				ready = append(ready, dependent)
+			}
+		}
+	}
+	if visited != len(dag.nodes) {
+		return ErrDAGCycle
+	}
+
+	return nil
+}
+
+// dependents returns the nodes depending on name directly, a node depending on name twice appears twice.
+func (dag *DAG) dependents(name string) []string {
+	var dependents []string
+	for _, other := range dag.order {
+		for _, dep := range dag.nodes[other].deps {
+			if dep == name {
+				dependents = append(dependents, other)
+			}
+		}
+	}
+
+	return dependents
+}
+
+type dagDone struct {
+	name   string
+	result *DAGResult
+}
+
+// Run runs all nodes on wp and returns the report along with the first error met,
+// the nodes not run by failure or ctx done are reported with ErrDAGSkipped.
+func (dag *DAG) Run(ctx context.Context, wp *WorkerPool) (DAGReport, error) {
+	if err := dag.validate(); err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var (
+		report   = make(DAGReport, len(dag.nodes))
+		pending  = make(map[string]int, len(dag.nodes)) // number of deps not finished
+		done     = make(chan *dagDone, len(dag.nodes))
+		running  int
+		firstErr error
+	)
+	dispatch := func(node *dagNode) {
+		inputs := make(map[string]interface{}, len(node.deps))
+		for _, dep := range node.deps {
+			inputs[dep] = report[dep].Output
+		}
+
+		running++
+		start := time.Now()
+		err := wp.SendJob(ctx, NewJobWrapperFromFunc(func(ctx context.Context, out chan interface{}) error {
+			output, err := node.proc(ctx, inputs)
+			out <- output
+
+			return err
+		}, func(out interface{}, err error) {
+			done <- &dagDone{name: node.name, result: &DAGResult{Output: out, Err: err, Duration: time.Since(start)}}
+		}))
+		if err != nil {
+			done <- &dagDone{name: node.name, result: &DAGResult{Err: err}}
+		}
+	}
+	// skip marks the nodes depending on name as skipped transitively
+	var skip func(name string)
+	skip = func(name string) {
+		for _, dependent := range dag.dependents(name) {
+			if _, ok := report[dependent]; !ok {
+				report[dependent] = &DAGResult{Err: ErrDAGSkipped}
+				skip(dependent)
+			}
+		}
+	}
+
+	for _, name := range dag.order {
+		if pending[name] = len(dag.nodes[name].deps); pending[name] == 0 {
+			dispatch(dag.nodes[name])
+		}
+	}
+	for running > 0 {
+		var d *dagDone
+		select {
+		case <-wp.closer:
+			// jobs queued are never taken once pool closed
+			for _, name := range dag.order {
+				if _, ok := report[name]; !ok {
+					report[name] = &DAGResult{Err: ErrWorkerPoolClosed}
+				}
+			}
+
+			return report, ErrWorkerPoolClosed
+		case d = <-done:
+		}
+		running--
+		report[d.name] = d.result
+
+		if d.result.Err != nil {
+			if firstErr == nil {
+				firstErr = fmt.Errorf("dag node %s failed: %w", d.name, d.result.Err)
+			}
+			if !dag.continueOnError {
+				cancel()
+			}
+			skip(d.name)
+
+			continue
+		}
+		if ctx.Err() != nil {
+			continue
+		}
+		for _, dependent := range dag.dependents(d.name) {
+			if pending[dependent]--; pending[dependent] == 0 {
+				if _, ok := report[dependent]; !ok {
+					dispatch(dag.nodes[dependent])
+				}
+			}
+		}
+	}
+
+	for _, name := range dag.order {
+		if _, ok := report[name]; !ok {
+			report[name] = &DAGResult{Err: ErrDAGSkipped}
+		}
+	}
+	if firstErr == nil && ctx.Err() != nil {
+		firstErr = ctx.Err()
+	}
+
+	return report, firstErr
+}
+
+func NewDAG(opts ...DAGOption) *DAG {
+	dag := &DAG{nodes: make(map[string]*dagNode)}
+	for _, opt := range opts {
+		opt(dag)
+	}
+
+	return dag
+}
@@ -0,0 +1,91 @@
+/*
+ *   Copyright (c) 2023 CodapeWild
+ *   All rights reserved.
+
+ *   Licensed under the Apache License, Version 2.0 (the "License");
+ *   you may not use this file except in compliance with the License.
+ *   You may obtain a copy of the License at
+
+ *   http://www.apache.org/licenses/LICENSE-2.0
+
+ *   Unless required by applicable law or agreed to in writing, software
+ *   distributed under the License is distributed on an "AS IS" BASIS,
+ *   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *   See the License for the specific language governing permissions and
+ *   limitations under the License.
+ */
+
+package threadpool
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestDAGRun(t *testing.T) {
+	wp := NewWorkerPool(4)
+	wp.Start()
+	defer wp.Close()
+
+	value := func(v int) DAGProcess {
+		return func(ctx context.Context, inputs map[string]interface{}) (interface{}, error) { return v, nil }
+	}
+	sum := func(ctx context.Context, inputs map[string]interface{}) (interface{}, error) {
+		var s int
+		for _, v := range inputs {
+			s += v.(int)
+		}
+
+		return s, nil
+	}
+	failed := errors.New("failed")
+	fail := func(ctx context.Context, inputs map[string]interface{}) (interface{}, error) { return nil, failed }
+
+	// a, b -> c -> d and a -> e, broken -> f
+	build := func(opts ...DAGOption) *DAG {
+		dag := NewDAG(opts...)
+		dag.Add("a", value(1))
+		dag.Add("b", value(2))
+		dag.Add("c", sum, "a", "b")
+		dag.Add("d", sum, "c")
+		dag.AddJob("e", NewJobWrapperFromFunc(func(ctx context.Context, out chan interface{}) error {
+			out <- DAGInputs(ctx)["a"].(int) * 10
+
+			return nil
+		}, nil), "a")
+
+		return dag
+	}
+
+	report, err := build().Run(context.TODO(), wp)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if report["d"].Output != 3 || report["e"].Output != 10 {
+		t.Fatalf("unexpected outputs d=%v e=%v", report["d"].Output, report["e"].Output)
+	}
+
+	dag := build(DAGContinueOnError())
+	dag.Add("broken", fail)
+	dag.Add("f", sum, "broken", "a")
+	report, err = dag.Run(context.TODO(), wp)
+	if !errors.Is(err, failed) {
+		t.Fatalf("expect failed got %v", err)
+	}
+	if !errors.Is(report["f"].Err, ErrDAGSkipped) || report["d"].Output != 3 {
+		t.Fatalf("unexpected report f=%v d=%v", report["f"].Err, report["d"].Output)
+	}
+
+	dag = build()
+	dag.Add("a2", value(0), "d")
+	dag.Add("d2", sum, "a2")
+	if err = dag.Add("d2", sum); !errors.Is(err, ErrDAGDuplicateNode) {
+		t.Fatalf("expect duplicate node got %v", err)
+	}
+	dag.Add("cycle1", sum, "cycle2")
+	dag.Add("cycle2", sum, "cycle1")
+	if _, err = dag.Run(context.TODO(), wp); !errors.Is(err, ErrDAGCycle) {
+		t.Fatalf("expect cycle got %v", err)
+	}
+}
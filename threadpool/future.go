@@ -0,0 +1,146 @@
+/*
+ *   Copyright (c) 2023 CodapeWild
+ *   All rights reserved.
+
+ *   Licensed under the Apache License, Version 2.0 (the "License");
+ *   you may not use this file except in compliance with the License.
+ *   You may obtain a copy of the License at
+
+ *   http://www.apache.org/licenses/LICENSE-2.0
+
+ *   Unless required by applicable law or agreed to in writing, software
+ *   distributed under the License is distributed on an "AS IS" BASIS,
+ *   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *   See the License for the specific language governing permissions and
+ *   limitations under the License.
+ */
+
+package threadpool
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+)
+
+// Future is the result of a job submitted by Submit.
+type Future[T any] struct {
+	once    sync.Once
+	done    chan struct{}
+	value   T
+	err     error
+	cancel  context.CancelFunc
+	started atomic.Bool // claimed by the job starting or by the pool closing first
+}
+
+// Wait blocks until the job finished or ctx done.
+func (f *Future[T]) Wait(ctx context.Context) (T, error) {
+	select {
+	case <-ctx.Done():
+		var zero T
+
+		return zero, ctx.Err()
+	case <-f.done:
+		return f.value, f.err
+	}
+}
+
+// Done returns a channel closed once the result is ready.
+func (f *Future[T]) Done() <-chan struct{} {
+	return f.done
+}
+
+// Cancel cancels the context of the job and completes the future with context.Canceled,
+// the job is skipped if not started yet.
+func (f *Future[T]) Cancel() {
+	var zero T
+	f.complete(zero, context.Canceled)
+}
+
+func (f *Future[T]) complete(value T, err error) {
+	f.once.Do(func() {
+		f.value = value
+		f.err = err
+		f.cancel()
+		close(f.done)
+	})
+}
+
+// Submit sends fn into pool as a job and returns the future of its result,
+// the future completes with ErrWorkerPoolClosed if the pool is closed before the job started.
+func Submit[T any](ctx context.Context, wp *WorkerPool, fn func(ctx context.Context) (T, error), opts ...JobOption) (*Future[T], error) {
+	ctx, cancel := context.WithCancel(ctx)
+	f := &Future[T]{done: make(chan struct{}), cancel: cancel}
+
+	job := NewJobWrapperFromFunc(func(ctx context.Context, out chan interface{}) error {
+		if !f.started.CompareAndSwap(false, true) {
+			return ErrWorkerPoolClosed
+		}
+		value, err := fn(ctx)
+		out <- value
+
+		return err
+	}, func(out interface{}, err error) {
+		value, _ := out.(T)
+		f.complete(value, err)
+	})
+	if err := wp.SendJob(ctx, job, opts...); err != nil {
+		cancel()
+
+		return nil, err
+	}
+	go func() {
+		select {
+		case <-f.done:
+		case <-wp.closer:
+			if f.started.CompareAndSwap(false, true) {
+				var zero T
+				f.complete(zero, ErrWorkerPoolClosed)
+			}
+		}
+	}()
+
+	return f, nil
+}
+
+// WaitAll waits for all the futures and returns the results in order, the first error met is returned.
+func WaitAll[T any](ctx context.Context, futures ...*Future[T]) ([]T, error) {
+	values := make([]T, len(futures))
+	for i, f := range futures {
+		value, err := f.Wait(ctx)
+		if err != nil {
+			return nil, err
+		}
+		values[i] = value
+	}
+
+	return values, nil
+}
+
+// WaitAny waits for the first future completed and returns its index and result.
+func WaitAny[T any](ctx context.Context, futures ...*Future[T]) (int, T, error) {
+	var (
+		first = make(chan int, len(futures))
+		stop  = make(chan struct{})
+	)
+	defer close(stop)
+
+	for i, f := range futures {
+		go func(i int, f *Future[T]) {
+			select {
+			case <-stop:
+			case <-f.done:
+				first <- i
+			}
+		}(i, f)
+	}
+
+	select {
+	case <-ctx.Done():
+		var zero T
+
+		return -1, zero, ctx.Err()
+	case i := <-first:
+		return i, futures[i].value, futures[i].err
+	}
+}
@@ -0,0 +1,110 @@
+/*
+ *   Copyright (c) 2023 CodapeWild
+ *   All rights reserved.
+
+ *   Licensed under the Apache License, Version 2.0 (the "License");
+ *   you may not use this file except in compliance with the License.
+ *   You may obtain a copy of the License at
+
+ *   http://www.apache.org/licenses/LICENSE-2.0
+
+ *   Unless required by applicable law or agreed to in writing, software
+ *   distributed under the License is distributed on an "AS IS" BASIS,
+ *   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *   See the License for the specific language governing permissions and
+ *   limitations under the License.
+ */
+
+package threadpool
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestFuture(t *testing.T) {
+	wp := NewWorkerPool(2)
+	wp.Start()
+	defer wp.Close()
+
+	var futures []*Future[int]
+	for i := 0; i < 5; i++ {
+		f, err := Submit(context.TODO(), wp, func(ctx context.Context) (int, error) {
+			time.Sleep(time.Duration(5-i) * time.Millisecond)
+
+			return i * i, nil
+		})
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		futures = append(futures, f)
+	}
+
+	values, err := WaitAll(context.TODO(), futures...)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	for i, v := range values {
+		if v != i*i {
+			t.Fatalf("expect %d got %d", i*i, v)
+		}
+	}
+
+	// the slow job is canceled and the fast one wins
+	slow, _ := Submit(context.TODO(), wp, func(ctx context.Context) (string, error) {
+		<-ctx.Done()
+
+		return "", ctx.Err()
+	})
+	fast, _ := Submit(context.TODO(), wp, func(ctx context.Context) (string, error) {
+		return "fast", nil
+	})
+	i, v, err := WaitAny(context.TODO(), slow, fast)
+	if err != nil || i != 1 || v != "fast" {
+		t.Fatalf("expect fast job first got %d %s %v", i, v, err)
+	}
+	slow.Cancel()
+	if _, err = slow.Wait(context.TODO()); !errors.Is(err, context.Canceled) {
+		t.Fatalf("expect canceled got %v", err)
+	}
+}
+
+func TestFuturePoolClosed(t *testing.T) {
+	wp := NewWorkerPool(2)
+	wp.Start()
+
+	var (
+		started = make(chan struct{})
+		release = make(chan struct{})
+	)
+	running, err := Submit(context.TODO(), wp, func(ctx context.Context) (string, error) {
+		close(started)
+		<-release
+
+		return "done", nil
+	})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	pending, err := Submit(context.TODO(), wp, func(ctx context.Context) (string, error) {
+		return "never", nil
+	}, JobNotBefore(time.Now().Add(time.Hour)))
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	<-started
+	wp.Close()
+
+	ctx, cancel := context.WithTimeout(context.TODO(), time.Second)
+	defer cancel()
+	if _, err = pending.Wait(ctx); !errors.Is(err, ErrWorkerPoolClosed) {
+		t.Fatalf("expect pool closed got %v", err)
+	}
+	// the job started before closing still completes with its result
+	close(release)
+	if v, err := running.Wait(ctx); err != nil || v != "done" {
+		t.Fatalf("expect done got %s %v", v, err)
+	}
+}
@@ -19,6 +19,7 @@ package threadpool
 
 import (
 	"context"
+	"time"
 )
 
 type JobProcess func(ctx context.Context, out chan interface{}) error
@@ -31,16 +32,31 @@ type Job interface {
 }
 
 type JobWrapper struct {
-	proc JobProcess
-	cb   JobCallback
+	ctx       context.Context // context the job submitted with
+	timeout   time.Duration   // max duration of processing, no limit if 0
+	priority  Priority
+	notBefore time.Time // the job is not dispatched before
+	queuedAt  time.Time // the time job put into queue
+	origin    Job       // the job sent into pool
+	class     string    // class of rate limit
+	admitted  bool      // rate limits applied
+	proc      JobProcess
+	cb        JobCallback
 }
 
+// Process runs the job with ctx, the context submitted with is used if ctx is nil.
 func (jw *JobWrapper) Process(ctx context.Context, out chan interface{}) error {
+	if ctx == nil {
+		ctx = jw.ctx
+	}
+
 	return jw.proc(ctx, out)
 }
 
 func (jw *JobWrapper) Callback(out interface{}, err error) {
-	jw.cb(out, err)
+	if jw.cb != nil {
+		jw.cb(out, err)
+	}
 }
 
 func NewJobWrapper(job Job) *JobWrapper {
@@ -59,7 +75,39 @@ func NewJobWrapperFromFunc(process JobProcess, callback JobCallback) *JobWrapper
 
 func NewJobWrapperWithContext(ctx context.Context, job Job) *JobWrapper {
 	return &JobWrapper{
-		proc: func(_ context.Context, out chan interface{}) error { return job.Process(ctx, out) },
+		ctx:  ctx,
+		proc: job.Process,
 		cb:   job.Callback,
 	}
 }
+
+type JobOption func(jw *JobWrapper)
+
+// JobWithTimeout limits the processing time of job, the context passed to Process is canceled
+// once timeout and the job fails with ErrTaskTimeout if it returns error by then.
+func JobWithTimeout(d time.Duration) JobOption {
+	return func(jw *JobWrapper) {
+		jw.timeout = d
+	}
+}
+
+// JobWithPriority puts job into the queue of class p, PriorityNormal by default.
+func JobWithPriority(p Priority) JobOption {
+	return func(jw *JobWrapper) {
+		jw.priority = p
+	}
+}
+
+// JobNotBefore holds job in scheduler until t.
+func JobNotBefore(t time.Time) JobOption {
+	return func(jw *JobWrapper) {
+		jw.notBefore = t
+	}
+}
+
+// JobWithClass sets the class job belongs to for rate limit.
+func JobWithClass(class string) JobOption {
+	return func(jw *JobWrapper) {
+		jw.class = class
+	}
+}
@@ -0,0 +1,130 @@
+/*
+ *   Copyright (c) 2023 CodapeWild
+ *   All rights reserved.
+
+ *   Licensed under the Apache License, Version 2.0 (the "License");
+ *   you may not use this file except in compliance with the License.
+ *   You may obtain a copy of the License at
+
+ *   http://www.apache.org/licenses/LICENSE-2.0
+
+ *   Unless required by applicable law or agreed to in writing, software
+ *   distributed under the License is distributed on an "AS IS" BASIS,
+ *   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *   See the License for the specific language governing permissions and
+ *   limitations under the License.
+ */
+
+package threadpool
+
+import (
+	"context"
+	"hash/fnv"
+	"sync"
+	"time"
+)
+
+const _defaultLanes = 64
+
+// lane runs the jobs of the keys hashed to it one by one in the order sent.
+type lane struct {
+	sync.Mutex
+	pending []*JobWrapper
+	running bool // a job of lane is dispatched and not finished yet
+}
+
+// WorkerPoolWithLanes sets the number of lanes keyed jobs hashed to, 64 by default.
+func WorkerPoolWithLanes(n int) WorkerPoolOption {
+	return func(wp *WorkerPool) {
+		if n > 0 {
+			wp.lanes = make([]*lane, n)
+		}
+	}
+}
+
+// SendKeyedJob sends job into the lane key hashed to, the jobs of the same key run in the order sent
+// while the jobs of different keys run in parallel. The error of dispatching is returned if the lane
+// is free, otherwise the job waits in lane and the error is passed to Callback later. A job not before
+// a future time is held by scheduler and keeps its lane busy until it runs.
+func (wp *WorkerPool) SendKeyedJob(ctx context.Context, key string, job Job, opts ...JobOption) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	select {
+	case <-wp.closer:
+		return ErrWorkerPoolClosed
+	default:
+	}
+
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	ln := wp.lanes[h.Sum32()%uint32(len(wp.lanes))]
+	jw := wp.wrapJob(ctx, job, opts)
+
+	ln.Lock()
+	if ln.running {
+		ln.pending = append(ln.pending, jw)
+		ln.Unlock()
+
+		return nil
+	}
+	ln.running = true
+	ln.Unlock()
+
+	if err := wp.dispatchLane(ln, jw); err != nil {
+		wp.laneNext(ln)
+
+		return err
+	}
+
+	return nil
+}
+
+// dispatchLane dispatches jw of lane, the job not before a future time is handed to scheduler.
+func (wp *WorkerPool) dispatchLane(ln *lane, jw *JobWrapper) error {
+	wrapped := wp.laneJob(ln, jw)
+	if jw.notBefore.After(time.Now()) {
+		wp.timer.add(&timedJob{at: jw.notBefore, job: wrapped})
+
+		return nil
+	}
+
+	return wp.dispatch(wrapped)
+}
+
+// laneJob wraps jw to dispatch the next job of lane once its callback returns.
+func (wp *WorkerPool) laneJob(ln *lane, jw *JobWrapper) *JobWrapper {
+	cb := jw.cb
+	wrapped := *jw
+	wrapped.cb = func(out interface{}, err error) {
+		defer wp.laneNext(ln)
+
+		if cb != nil {
+			cb(out, err)
+		}
+	}
+
+	return &wrapped
+}
+
+// laneNext dispatches the next job waiting in lane or sets lane free.
+func (wp *WorkerPool) laneNext(ln *lane) {
+	ln.Lock()
+	if len(ln.pending) == 0 {
+		ln.running = false
+		ln.Unlock()
+
+		return
+	}
+	next := ln.pending[0]
+	ln.pending = ln.pending[1:]
+	ln.Unlock()
+
+	// dispatch in new goroutine since the worker calling back may be the only one taking jobs
+	go func() {
+		if err := wp.dispatchLane(ln, next); err != nil {
+			callback(next, nil, err)
+			wp.laneNext(ln)
+		}
+	}()
+}
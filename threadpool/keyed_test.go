@@ -0,0 +1,108 @@
+/*
+ *   Copyright (c) 2023 CodapeWild
+ *   All rights reserved.
+
+ *   Licensed under the Apache License, Version 2.0 (the "License");
+ *   you may not use this file except in compliance with the License.
+ *   You may obtain a copy of the License at
+
+ *   http://www.apache.org/licenses/LICENSE-2.0
+
+ *   Unless required by applicable law or agreed to in writing, software
+ *   distributed under the License is distributed on an "AS IS" BASIS,
+ *   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *   See the License for the specific language governing permissions and
+ *   limitations under the License.
+ */
+
+package threadpool
+
+import (
+	"context"
+	"math/rand"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestWorkerPoolKeyedJob(t *testing.T) {
+	wp := NewWorkerPool(4)
+	wp.Start()
+	defer wp.Close()
+
+	var (
+		keys  = []string{"device-a", "device-b", "device-c"}
+		n     = 20
+		mu    sync.Mutex
+		order = make(map[string][]int)
+		wg    sync.WaitGroup
+	)
+	for i := 0; i < n; i++ {
+		for _, key := range keys {
+			wg.Add(1)
+			err := wp.SendKeyedJob(context.TODO(), key, NewJobWrapperFromFunc(func(ctx context.Context, out chan interface{}) error {
+				time.Sleep(time.Duration(rand.Intn(500)) * time.Microsecond)
+				mu.Lock()
+				order[key] = append(order[key], i)
+				mu.Unlock()
+
+				return nil
+			}, func(out interface{}, err error) { wg.Done() }))
+			if err != nil {
+				t.Fatal(err.Error())
+			}
+		}
+	}
+	wg.Wait()
+
+	for _, key := range keys {
+		if len(order[key]) != n {
+			t.Fatalf("expect %d jobs of %s got %d", n, key, len(order[key]))
+		}
+		for i, v := range order[key] {
+			if v != i {
+				t.Fatalf("%s run out of order: %v", key, order[key])
+			}
+		}
+	}
+}
+
+func TestWorkerPoolKeyedJobNotBefore(t *testing.T) {
+	wp := NewWorkerPool(4)
+	wp.Start()
+	defer wp.Close()
+
+	var (
+		start = time.Now()
+		ran   = make(chan string, 2)
+	)
+	for _, name := range []string{"delayed", "next"} {
+		var opts []JobOption
+		if name == "delayed" {
+			opts = append(opts, JobNotBefore(start.Add(30*time.Millisecond)))
+		}
+		err := wp.SendKeyedJob(context.TODO(), "device-a", NewJobWrapperFromFunc(func(ctx context.Context, out chan interface{}) error {
+			ran <- name
+
+			return nil
+		}, nil), opts...)
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+	}
+
+	// the job behind waits for the delayed one in the same lane
+	for _, expect := range []string{"delayed", "next"} {
+		select {
+		case name := <-ran:
+			if name != expect {
+				t.Fatalf("expect %s run got %s", expect, name)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("expect %s run", expect)
+		}
+	}
+	if time.Since(start) < 30*time.Millisecond {
+		t.Fatal("expect keyed job run not before the time given")
+	}
+}
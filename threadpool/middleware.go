@@ -17,15 +17,146 @@
 
 package threadpool
 
-import "net/http"
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+	"time"
 
-func WorkerPoolHTTPWrapper(wp *WorkerPool, next http.Handler) http.Handler {
+	dkhttp "github.com/CodapeWild/devkit/net/http"
+)
+
+// states of the request wrapped in job
+const (
+	_reqWaiting int32 = iota
+	_reqRunning
+	_reqAbandoned // abandoned by queue wait timeout or pool closed
+)
+
+type httpWrapper struct {
+	queueTimeout time.Duration // max duration request waits for worker, no limit if 0
+	retryAfter   time.Duration // value of Retry-After header once rejected
+}
+
+type HTTPWrapperOption func(hw *httpWrapper)
+
+// HTTPWithQueueTimeout rejects the request with 503 if it is not taken by worker in d.
+func HTTPWithQueueTimeout(d time.Duration) HTTPWrapperOption {
+	return func(hw *httpWrapper) {
+		hw.queueTimeout = d
+	}
+}
+
+// HTTPWithRetryAfter sets Retry-After header of the responses rejected, 1 second by default.
+func HTTPWithRetryAfter(d time.Duration) HTTPWrapperOption {
+	return func(hw *httpWrapper) {
+		hw.retryAfter = d
+	}
+}
+
+// WorkerPoolHTTPWrapper serves next inside wp with the request context, the requests are rejected
+// with 429 if the queue of wp is full or rate limited and 503 if wp is closed or the queue wait timeout,
+// the errors are written as net/http.JSONRespMessage.
+func WorkerPoolHTTPWrapper(wp *WorkerPool, next http.Handler, opts ...HTTPWrapperOption) http.Handler {
 	if wp == nil {
 		return next
-	} else {
-		return http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
-			// todo: integrate with net.http message to complete this job
-			// copy body from request and send it to next handler
+	}
+
+	hw := &httpWrapper{retryAfter: time.Second}
+	for _, opt := range opts {
+		opt(hw)
+	}
+
+	return http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+		var (
+			ctx, cancel = context.WithCancel(req.Context())
+			state       atomic.Int32
+			done        = make(chan struct{})
+			abandoned   = make(chan struct{}) // closed once queue wait timeout
+			jobErr      error
+		)
+		defer cancel()
+
+		// the context is canceled to stop waiting in queue unless the job has started,
+		// the job left in a buffered queue is skipped once taken by worker
+		if hw.queueTimeout > 0 {
+			timer := time.AfterFunc(hw.queueTimeout, func() {
+				if state.CompareAndSwap(_reqWaiting, _reqAbandoned) {
+					close(abandoned)
+					cancel()
+				}
+			})
+			defer timer.Stop()
+		}
+
+		job := NewJobWrapperFromFunc(func(ctx context.Context, out chan interface{}) error {
+			if !state.CompareAndSwap(_reqWaiting, _reqRunning) {
+				return ErrSendJobTimeout
+			}
+			next.ServeHTTP(resp, req.WithContext(ctx))
+
+			return nil
+		}, func(out interface{}, err error) {
+			jobErr = err
+			close(done)
 		})
+		if err := wp.SendJob(ctx, job); err != nil {
+			hw.reject(resp, hw.abandonedErr(req, &state, err))
+
+			return
+		}
+
+		select {
+		case <-done:
+			if jobErr != nil {
+				hw.reject(resp, hw.abandonedErr(req, &state, jobErr))
+			}
+		case <-abandoned:
+			hw.reject(resp, ErrSendJobTimeout)
+		case <-wp.closer:
+			if state.CompareAndSwap(_reqWaiting, _reqAbandoned) {
+				hw.reject(resp, ErrWorkerPoolClosed)
+
+				return
+			}
+			if state.Load() == _reqRunning {
+				<-done
+			} else {
+				hw.reject(resp, ErrSendJobTimeout)
+			}
+		}
+	})
+}
+
+// abandonedErr returns ErrSendJobTimeout if the request is canceled by queue wait timeout.
+func (hw *httpWrapper) abandonedErr(req *http.Request, state *atomic.Int32, err error) error {
+	if errors.Is(err, context.Canceled) && state.Load() == _reqAbandoned && req.Context().Err() == nil {
+		return ErrSendJobTimeout
+	}
+
+	return err
+}
+
+// reject writes err with the status code it maps to, nothing is written if the client has gone.
+func (hw *httpWrapper) reject(resp http.ResponseWriter, err error) {
+	var status int
+	switch {
+	case errors.Is(err, ErrQueueFull), errors.Is(err, ErrRateLimited):
+		status = http.StatusTooManyRequests
+	case errors.Is(err, ErrSendJobTimeout), errors.Is(err, ErrWorkerPoolClosed):
+		status = http.StatusServiceUnavailable
+	case errors.Is(err, context.Canceled):
+		return
+	default:
+		status = http.StatusInternalServerError
+	}
+
+	if status != http.StatusInternalServerError {
+		resp.Header().Set("Retry-After", strconv.Itoa(int((hw.retryAfter+time.Second-1)/time.Second)))
 	}
+	resp.Header().Set("Content-Type", "application/json")
+	resp.WriteHeader(status)
+	dkhttp.NewJSONRespMessage(status, dkhttp.JSONRespMsgWithMessage(err.Error())).WriteBy(resp)
 }
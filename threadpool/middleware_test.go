@@ -0,0 +1,82 @@
+/*
+ *   Copyright (c) 2023 CodapeWild
+ *   All rights reserved.
+
+ *   Licensed under the Apache License, Version 2.0 (the "License");
+ *   you may not use this file except in compliance with the License.
+ *   You may obtain a copy of the License at
+
+ *   http://www.apache.org/licenses/LICENSE-2.0
+
+ *   Unless required by applicable law or agreed to in writing, software
+ *   distributed under the License is distributed on an "AS IS" BASIS,
+ *   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *   See the License for the specific language governing permissions and
+ *   limitations under the License.
+ */
+
+package threadpool
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestWorkerPoolHTTPWrapper(t *testing.T) {
+	release := make(chan struct{})
+	next := http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+		if req.URL.Path == "/slow" {
+			<-release
+		}
+		resp.Write([]byte("ok"))
+	})
+
+	tests := []struct {
+		name   string
+		wp     *WorkerPool
+		opts   []HTTPWrapperOption
+		status int
+	}{
+		{"reject", NewWorkerPool(1, WorkerPoolWithQueue(0, QueueReject)), nil, http.StatusTooManyRequests},
+		{"queue_timeout", NewWorkerPool(1), []HTTPWrapperOption{HTTPWithQueueTimeout(20 * time.Millisecond), HTTPWithRetryAfter(2 * time.Second)}, http.StatusServiceUnavailable},
+		{"buffered_queue_timeout", NewWorkerPool(1, WorkerPoolWithQueue(10, QueueBlock)), []HTTPWrapperOption{HTTPWithQueueTimeout(20 * time.Millisecond)}, http.StatusServiceUnavailable},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.wp.Start()
+			defer tt.wp.Close()
+			// wait for the worker ready to take job
+			time.Sleep(10 * time.Millisecond)
+			handler := WorkerPoolHTTPWrapper(tt.wp, next, tt.opts...)
+
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+			if rec.Code != http.StatusOK || rec.Body.String() != "ok" {
+				t.Fatalf("expect ok got %d %s", rec.Code, rec.Body.String())
+			}
+
+			// the only worker is occupied by slow request
+			slow := make(chan struct{})
+			go func() {
+				handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/slow", nil))
+				close(slow)
+			}()
+			time.Sleep(10 * time.Millisecond)
+
+			// rejected right away instead of waiting for the worker to take job
+			start := time.Now()
+			rec = httptest.NewRecorder()
+			handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+			if elapsed := time.Since(start); elapsed > 200*time.Millisecond {
+				t.Fatalf("expect rejected in time got %s", elapsed)
+			}
+			if rec.Code != tt.status || rec.Header().Get("Retry-After") == "" {
+				t.Fatalf("expect %d with Retry-After got %d %v", tt.status, rec.Code, rec.Header())
+			}
+			release <- struct{}{}
+			<-slow
+		})
+	}
+}
@@ -20,68 +20,345 @@ package threadpool
 import (
 	"context"
 	"errors"
+	"fmt"
+	"log"
 	"sync"
+	"time"
 )
 
 var (
 	ErrWorkerPoolClosed = errors.New("worker pool has closed")
 	ErrSendJobTimeout   = errors.New("sending job to worker pool timeout")
 	ErrTaskTimeout      = errors.New("task timeout")
+	ErrQueueFull        = errors.New("worker pool queue is full")
+	ErrJobPanicked      = errors.New("job panicked")
+)
+
+// QueuePolicy decides what SendJob does when no worker or queue slot is free for the job.
+type QueuePolicy int
+
+const (
+	QueueBlock      QueuePolicy = iota // wait until the job is taken or ctx done
+	QueueReject                        // fail with ErrQueueFull
+	QueueCallerRuns                    // run the job in the goroutine calling SendJob
+)
+
+// Priority is the class of job, workers always take the job of higher class first.
+type Priority int
+
+const (
+	PriorityHigh Priority = iota
+	PriorityNormal
+	PriorityLow
+	_priorityClasses
 )
 
 type WorkerPool struct {
 	sync.Once
-	maxThreads int
-	jobchan    chan Job
-	closer     chan struct{}
+	mu          sync.Mutex
+	maxThreads  int                        // max number of jobs executing at the same time, i.e. workers running
+	minWorkers  int                        // workers idle are not reaped below
+	maxWorkers  int                        // workers are not scaled up beyond
+	idleTimeout time.Duration              // workers idle for longer exit, never if 0
+	retiring    int                        // number of workers to exit after shrinking
+	resized     chan struct{}              // closed to wake up workers to check retiring
+	started     bool                       // workers are started
+	queues      [_priorityClasses]chan Job // job queues by priority
+	queueSize   int                        // size of each queue, unbuffered if 0
+	queuePolicy QueuePolicy
+	timer       *scheduler // jobs waiting for their time
+	lanes       []*lane    // lanes of keyed jobs
+	limit       *jobLimit  // rate limit of all jobs
+	classLimits map[string]*jobLimit
+	stats       poolStats
+	hooks       PoolHooks
+	closer      chan struct{}
+}
+
+type WorkerPoolOption func(wp *WorkerPool)
+
+// WorkerPoolWithQueue buffers at most size jobs of each priority waiting for workers,
+// policy applies once the queue is full.
+func WorkerPoolWithQueue(size int, policy QueuePolicy) WorkerPoolOption {
+	return func(wp *WorkerPool) {
+		wp.queueSize = size
+		wp.queuePolicy = policy
+	}
 }
 
 func (wp *WorkerPool) Start() {
 	wp.Do(func() {
+		wp.mu.Lock()
+		wp.started = true
 		for i := 0; i < wp.maxThreads; i++ {
 			go wp.workLoop()
 		}
+		wp.mu.Unlock()
+
+		go wp.timer.run(wp.closer, wp.dispatchTimed)
 	})
 }
 
-func (wp *WorkerPool) SendJob(ctx context.Context, job Job) error {
+// SendJob queues job for workers, what happens if the queue is full depends on QueuePolicy.
+// The job is processed with ctx and the options applied, a job not before a future time
+// is held by scheduler and the queue policy applies when it is due, failures are passed
+// to Callback then.
+func (wp *WorkerPool) SendJob(ctx context.Context, job Job, opts ...JobOption) error {
 	if err := ctx.Err(); err != nil {
 		return err
 	}
+	select {
+	case <-wp.closer:
+		return ErrWorkerPoolClosed
+	default:
+	}
+
+	jw := wp.wrapJob(ctx, job, opts)
+	if jw.notBefore.After(time.Now()) {
+		wp.timer.add(&timedJob{at: jw.notBefore, job: jw})
+
+		return nil
+	}
+
+	return wp.dispatch(jw)
+}
+
+// SendJobAfter runs job once d passed.
+func (wp *WorkerPool) SendJobAfter(ctx context.Context, d time.Duration, job Job, opts ...JobOption) error {
+	return wp.SendJob(ctx, job, append(opts, JobNotBefore(time.Now().Add(d)))...)
+}
 
+// SendRecurringJob runs job every time sched fires until ctx done or pool closed.
+func (wp *WorkerPool) SendRecurringJob(ctx context.Context, sched Schedule, job Job, opts ...JobOption) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
 	select {
 	case <-wp.closer:
 		return ErrWorkerPoolClosed
-	case <-ctx.Done():
-		if err := ctx.Err(); err != nil {
+	default:
+	}
+
+	next := sched.Next(time.Now())
+	if next.IsZero() {
+		return ErrInvalidSchedule
+	}
+	wp.timer.add(&timedJob{at: next, job: wp.wrapJob(ctx, job, opts), sched: sched})
+
+	return nil
+}
+
+func (wp *WorkerPool) wrapJob(ctx context.Context, job Job, opts []JobOption) *JobWrapper {
+	jw := NewJobWrapperWithContext(ctx, job)
+	jw.origin = job
+	jw.priority = PriorityNormal
+	for _, opt := range opts {
+		opt(jw)
+	}
+	if jw.priority < PriorityHigh || jw.priority > PriorityLow {
+		jw.priority = PriorityNormal
+	}
+
+	return jw
+}
+
+// dispatch puts job into the queue of its priority by QueuePolicy after rate limits applied,
+// the job delayed by rate limit is held by scheduler.
+func (wp *WorkerPool) dispatch(jw *JobWrapper) error {
+	wait, err := wp.admit(jw)
+	if err != nil {
+		wp.jobRejected(jw, err)
+
+		return err
+	}
+	if wait > 0 {
+		wp.timer.add(&timedJob{at: time.Now().Add(wait), job: jw})
+
+		return nil
+	}
+
+	queue := wp.queues[jw.priority]
+	jw.queuedAt = time.Now()
+	select {
+	case queue <- jw:
+		if len(queue) != 0 {
+			wp.grow()
+		}
+
+		return nil
+	default:
+		wp.grow()
+	}
+
+	if wp.queuePolicy != QueueBlock {
+		if wp.queuePolicy == QueueReject {
+			wp.jobRejected(jw, ErrQueueFull)
+
+			return ErrQueueFull
+		}
+		wp.run(jw)
+
+		return nil
+	}
+
+	select {
+	case <-wp.closer:
+		return ErrWorkerPoolClosed
+	case <-jw.ctx.Done():
+		if err := jw.ctx.Err(); err != nil {
 			return err
 		}
-	case wp.jobchan <- NewJobWrapperWithContext(ctx, job):
+	case queue <- jw:
 	}
 
 	return nil
 }
 
+// dispatchTimed dispatches the job due, recurring job is scheduled for the next time.
+func (wp *WorkerPool) dispatchTimed(tj *timedJob) {
+	if err := tj.job.ctx.Err(); err != nil {
+		if tj.sched == nil {
+			callback(tj.job, nil, err)
+		}
+
+		return
+	}
+	// recurring job is dispatched as a copy since the runs may overlap
+	jw := tj.job
+	if tj.sched != nil {
+		copied := *tj.job
+		jw = &copied
+	}
+	if err := wp.dispatch(jw); err != nil {
+		callback(jw, nil, err)
+	}
+	if tj.sched == nil {
+		return
+	}
+	// skip the times missed while dispatching was blocked
+	next := tj.sched.Next(tj.at)
+	if now := time.Now(); next.Before(now) {
+		next = tj.sched.Next(now)
+	}
+	if !next.IsZero() {
+		wp.timer.add(&timedJob{at: next, job: tj.job, sched: tj.sched})
+	}
+}
+
 func (wp *WorkerPool) workLoop() {
 	for {
-		select {
-		case <-wp.closer:
+		wp.mu.Lock()
+		resized := wp.resized
+		wp.mu.Unlock()
+		if wp.retire(false) {
+			return
+		}
+
+		var (
+			idle  <-chan time.Time
+			timer *time.Timer
+		)
+		if wp.idleTimeout > 0 {
+			timer = time.NewTimer(wp.idleTimeout)
+			idle = timer.C
+		}
+		job, idled, ok := wp.nextJob(resized, idle)
+		if timer != nil {
+			timer.Stop()
+		}
+		if !ok || (idled && wp.retire(true)) {
 			return
-		case job := <-wp.jobchan:
-			go func(job Job) {
-				out := make(chan interface{})
-				err := job.Process(nil, out)
-				if jw, ok := job.(*JobWrapper); ok && jw.cb != nil {
-					select {
-					case <-wp.closer:
-						return
-					case o := <-out:
-						job.Callback(o, err)
-					}
-				}
-			}(job)
 		}
+		if job != nil {
+			wp.run(job)
+		}
+	}
+}
+
+// nextJob takes the job of the highest priority waiting, it blocks until a job arrives
+// or woken by resizing and idle timeout, ok is false if pool closed.
+func (wp *WorkerPool) nextJob(resized chan struct{}, idle <-chan time.Time) (job Job, idled, ok bool) {
+	for _, queue := range wp.queues {
+		select {
+		case job := <-queue:
+			return job, false, true
+		default:
+		}
+	}
+
+	select {
+	case <-wp.closer:
+		return nil, false, false
+	case <-resized:
+		return nil, false, true
+	case <-idle:
+		return nil, true, true
+	case job := <-wp.queues[PriorityHigh]:
+		return job, false, true
+	case job := <-wp.queues[PriorityNormal]:
+		return job, false, true
+	case job := <-wp.queues[PriorityLow]:
+		return job, false, true
+	}
+}
+
+// run executes job in the current goroutine, the output sent into out is passed to Callback.
+func (wp *WorkerPool) run(job Job) {
+	jw, ok := job.(*JobWrapper)
+	if !ok {
+		jw = NewJobWrapper(job)
+		jw.origin = job
+	}
+	ctx := context.Background()
+	if jw.ctx != nil {
+		ctx = jw.ctx
+	}
+	if jw.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, jw.timeout)
+		defer cancel()
+	}
+
+	out := make(chan interface{}, 1)
+	wp.jobStarted(jw)
+	start := time.Now()
+	recovered, err := process(ctx, jw, out)
+	wp.jobFinished(jw, time.Since(start), err, recovered)
+
+	var o interface{}
+	select {
+	case o = <-out:
+	default:
+	}
+	callback(jw, o, err)
+}
+
+// process calls job.Process and converts panic into error, the job is skipped if ctx done already.
+func process(ctx context.Context, job Job, out chan interface{}) (recovered any, err error) {
+	defer func() {
+		if recovered = recover(); recovered != nil {
+			err = fmt.Errorf("%w: %v", ErrJobPanicked, recovered)
+		}
+		if err != nil && errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			err = ErrTaskTimeout
+		}
+	}()
+
+	if err = ctx.Err(); err != nil {
+		return nil, err
 	}
+
+	return nil, job.Process(ctx, out)
+}
+
+func callback(job Job, out interface{}, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("job callback panicked: %v", r)
+		}
+	}()
+
+	job.Callback(out, err)
 }
 
 func (wp *WorkerPool) Close() {
@@ -92,10 +369,34 @@ func (wp *WorkerPool) Close() {
 	}
 }
 
-func NewWorkerPool(n int) *WorkerPool {
-	return &WorkerPool{
+// NewWorkerPool returns a pool running at most n jobs at the same time,
+// n is kept in the bounds if WorkerPoolWithScaling applied.
+func NewWorkerPool(n int, opts ...WorkerPoolOption) *WorkerPool {
+	if n <= 0 {
+		n = 1
+	}
+
+	wp := &WorkerPool{
 		maxThreads: n,
-		jobchan:    make(chan Job),
+		minWorkers: n,
+		maxWorkers: n,
+		resized:    make(chan struct{}),
+		timer:      newScheduler(),
 		closer:     make(chan struct{}),
 	}
+	for _, opt := range opts {
+		opt(wp)
+	}
+	wp.maxThreads = min(max(wp.maxThreads, wp.minWorkers), wp.maxWorkers)
+	if wp.lanes == nil {
+		wp.lanes = make([]*lane, _defaultLanes)
+	}
+	for i := range wp.lanes {
+		wp.lanes[i] = &lane{}
+	}
+	for i := range wp.queues {
+		wp.queues[i] = make(chan Job, wp.queueSize)
+	}
+
+	return wp
 }
@@ -19,7 +19,10 @@ package threadpool
 
 import (
 	"context"
+	"errors"
 	"log"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 )
@@ -51,7 +54,8 @@ func TestRunWorkerPool(t *testing.T) {
 
 	for i := 0; i < 100; i++ {
 		go func() {
-			ctx, _ := context.WithTimeout(context.Background(), time.Second)
+			ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+			defer cancel()
 			wp.SendJob(ctx, &mockJob{})
 		}()
 	}
@@ -59,3 +63,164 @@ func TestRunWorkerPool(t *testing.T) {
 
 	wp.Close()
 }
+
+func TestWorkerPoolBoundedConcurrency(t *testing.T) {
+	var (
+		n             = 3
+		running, peak int32
+		wg            sync.WaitGroup
+	)
+	wp := NewWorkerPool(n)
+	wp.Start()
+	defer wp.Close()
+
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		err := wp.SendJob(context.TODO(), NewJobWrapperFromFunc(func(ctx context.Context, out chan interface{}) error {
+			defer wg.Done()
+
+			cur := atomic.AddInt32(&running, 1)
+			for {
+				if p := atomic.LoadInt32(&peak); cur <= p || atomic.CompareAndSwapInt32(&peak, p, cur) {
+					break
+				}
+			}
+			time.Sleep(5 * time.Millisecond)
+			atomic.AddInt32(&running, -1)
+
+			return nil
+		}, nil))
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+	}
+	wg.Wait()
+
+	if peak > int32(n) {
+		t.Fatalf("expect at most %d jobs running got %d", n, peak)
+	}
+}
+
+func TestWorkerPoolQueuePolicy(t *testing.T) {
+	release := make(chan struct{})
+	blocking := NewJobWrapperFromFunc(func(ctx context.Context, out chan interface{}) error {
+		<-release
+
+		return nil
+	}, nil)
+
+	wp := NewWorkerPool(1, WorkerPoolWithQueue(1, QueueReject))
+	wp.Start()
+	defer wp.Close()
+
+	// one job occupies the worker and the other one the queue
+	if err := wp.SendJob(context.TODO(), blocking); err != nil {
+		t.Fatal(err.Error())
+	}
+	time.Sleep(10 * time.Millisecond)
+	if err := wp.SendJob(context.TODO(), blocking); err != nil {
+		t.Fatal(err.Error())
+	}
+	if err := wp.SendJob(context.TODO(), blocking); !errors.Is(err, ErrQueueFull) {
+		t.Fatalf("expect ErrQueueFull got %v", err)
+	}
+
+	// the job runs in caller goroutine once queue full
+	wp.queuePolicy = QueueCallerRuns
+	var ran bool
+	err := wp.SendJob(context.TODO(), NewJobWrapperFromFunc(func(ctx context.Context, out chan interface{}) error {
+		ran = true
+
+		return nil
+	}, nil))
+	if err != nil || !ran {
+		t.Fatalf("expect job run by caller got %v", err)
+	}
+	close(release)
+}
+
+func TestWorkerPoolJobTimeoutAndPanic(t *testing.T) {
+	wp := NewWorkerPool(2)
+	wp.Start()
+	defer wp.Close()
+
+	errs := make(chan error, 2)
+	callback := func(out interface{}, err error) { errs <- err }
+
+	err := wp.SendJob(context.TODO(), NewJobWrapperFromFunc(func(ctx context.Context, out chan interface{}) error {
+		<-ctx.Done()
+
+		return ctx.Err()
+	}, callback), JobWithTimeout(10*time.Millisecond))
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if err = <-errs; !errors.Is(err, ErrTaskTimeout) {
+		t.Fatalf("expect ErrTaskTimeout got %v", err)
+	}
+
+	err = wp.SendJob(context.TODO(), NewJobWrapperFromFunc(func(ctx context.Context, out chan interface{}) error {
+		panic("boom")
+	}, callback))
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if err = <-errs; !errors.Is(err, ErrJobPanicked) {
+		t.Fatalf("expect ErrJobPanicked got %v", err)
+	}
+}
+
+func TestWorkerPoolStatsAndHooks(t *testing.T) {
+	var started, finished, panicked, rejected atomic.Int32
+	wp := NewWorkerPool(1, WorkerPoolWithQueue(1, QueueReject), WorkerPoolWithHooks(PoolHooks{
+		OnStart:  func(job Job) { started.Add(1) },
+		OnFinish: func(job Job, latency time.Duration, err error) { finished.Add(1) },
+		OnPanic:  func(job Job, recovered any) { panicked.Add(1) },
+		OnReject: func(job Job, err error) { rejected.Add(1) },
+	}))
+
+	var (
+		release = make(chan struct{})
+		wg      sync.WaitGroup
+	)
+	job := func(fail bool) Job {
+		wg.Add(1)
+
+		return NewJobWrapperFromFunc(func(ctx context.Context, out chan interface{}) error {
+			<-release
+			if fail {
+				panic("boom")
+			}
+
+			return nil
+		}, func(out interface{}, err error) { wg.Done() })
+	}
+
+	// one job queued before start and the next one rejected
+	wp.SendJob(context.TODO(), job(false))
+	if err := wp.SendJob(context.TODO(), job(false)); !errors.Is(err, ErrQueueFull) {
+		t.Fatalf("expect ErrQueueFull got %v", err)
+	}
+	wg.Done()
+	if stats := wp.Stats(); stats.Queued != 1 || stats.Rejected != 1 {
+		t.Fatalf("unexpected stats %+v", stats)
+	}
+
+	wp.Start()
+	defer wp.Close()
+	time.Sleep(10 * time.Millisecond)
+	if stats := wp.Stats(); stats.Active != 1 || stats.Queued != 0 {
+		t.Fatalf("unexpected stats %+v", stats)
+	}
+	wp.SendJob(context.TODO(), job(true))
+	close(release)
+	wg.Wait()
+
+	stats := wp.Stats()
+	if stats.Completed != 2 || stats.Failed != 1 || stats.Panicked != 1 || stats.Active != 0 {
+		t.Fatalf("unexpected stats %+v", stats)
+	}
+	if started.Load() != 2 || finished.Load() != 2 || panicked.Load() != 1 || rejected.Load() != 1 {
+		t.Fatalf("unexpected hooks called %d %d %d %d", started.Load(), finished.Load(), panicked.Load(), rejected.Load())
+	}
+}
@@ -0,0 +1,172 @@
+/*
+ *   Copyright (c) 2023 CodapeWild
+ *   All rights reserved.
+
+ *   Licensed under the Apache License, Version 2.0 (the "License");
+ *   you may not use this file except in compliance with the License.
+ *   You may obtain a copy of the License at
+
+ *   http://www.apache.org/licenses/LICENSE-2.0
+
+ *   Unless required by applicable law or agreed to in writing, software
+ *   distributed under the License is distributed on an "AS IS" BASIS,
+ *   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *   See the License for the specific language governing permissions and
+ *   limitations under the License.
+ */
+
+package threadpool
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+var ErrRateLimited = errors.New("rate limited")
+
+// RateLimiter is a token bucket refilled at rate tokens per second and holding at most burst tokens.
+type RateLimiter struct {
+	sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64 // negative if tokens reserved ahead
+	last   time.Time
+}
+
+// Allow takes a token if available.
+func (rl *RateLimiter) Allow() bool {
+	rl.Lock()
+	defer rl.Unlock()
+
+	rl.refill()
+	if rl.tokens < 1 {
+		return false
+	}
+	rl.tokens--
+
+	return true
+}
+
+// Reserve takes a token ahead and returns the duration to wait before using it.
+func (rl *RateLimiter) Reserve() time.Duration {
+	rl.Lock()
+	defer rl.Unlock()
+
+	rl.refill()
+	rl.tokens--
+	if rl.tokens >= 0 {
+		return 0
+	}
+
+	return time.Duration(-rl.tokens / rl.rate * float64(time.Second))
+}
+
+// Wait blocks until a token is available or ctx done, the token reserved is kept if ctx done.
+func (rl *RateLimiter) Wait(ctx context.Context) error {
+	wait := rl.Reserve()
+	if wait == 0 {
+		return nil
+	}
+
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// giveBack returns the token taken by Allow or Reserve but not used.
+func (rl *RateLimiter) giveBack() {
+	rl.Lock()
+	defer rl.Unlock()
+
+	rl.refill()
+	rl.tokens = min(rl.burst, rl.tokens+1)
+}
+
+func (rl *RateLimiter) refill() {
+	now := time.Now()
+	rl.tokens = min(rl.burst, rl.tokens+now.Sub(rl.last).Seconds()*rl.rate)
+	rl.last = now
+}
+
+// NewRateLimiter returns a limiter allowing rate jobs per second with burst, the bucket starts full.
+func NewRateLimiter(rate float64, burst int) *RateLimiter {
+	if rate <= 0 {
+		rate = 1
+	}
+	if burst <= 0 {
+		burst = 1
+	}
+
+	return &RateLimiter{rate: rate, burst: float64(burst), tokens: float64(burst), last: time.Now()}
+}
+
+// RateLimitMode decides what happens to the job exceeding rate limit.
+type RateLimitMode int
+
+const (
+	RateLimitDelay  RateLimitMode = iota // hold the job in scheduler until token available
+	RateLimitReject                      // fail with ErrRateLimited
+)
+
+type jobLimit struct {
+	rl   *RateLimiter
+	mode RateLimitMode
+}
+
+// WorkerPoolWithRateLimit limits the rate of all jobs dispatched.
+func WorkerPoolWithRateLimit(rl *RateLimiter, mode RateLimitMode) WorkerPoolOption {
+	return func(wp *WorkerPool) {
+		wp.limit = &jobLimit{rl: rl, mode: mode}
+	}
+}
+
+// WorkerPoolWithClassRateLimit limits the rate of jobs sent with JobWithClass(class),
+// global rate limit applies as well.
+func WorkerPoolWithClassRateLimit(class string, rl *RateLimiter, mode RateLimitMode) WorkerPoolOption {
+	return func(wp *WorkerPool) {
+		if wp.classLimits == nil {
+			wp.classLimits = make(map[string]*jobLimit)
+		}
+		wp.classLimits[class] = &jobLimit{rl: rl, mode: mode}
+	}
+}
+
+// admit applies rate limits to jw once and returns the duration to delay dispatching,
+// the tokens taken are given back if any limit rejects.
+func (wp *WorkerPool) admit(jw *JobWrapper) (time.Duration, error) {
+	if jw.admitted {
+		return 0, nil
+	}
+	jw.admitted = true
+
+	var (
+		wait  time.Duration
+		taken []*RateLimiter
+	)
+	for _, limit := range []*jobLimit{wp.classLimits[jw.class], wp.limit} {
+		if limit == nil {
+			continue
+		}
+		if limit.mode == RateLimitReject {
+			if !limit.rl.Allow() {
+				for _, rl := range taken {
+					rl.giveBack()
+				}
+
+				return 0, ErrRateLimited
+			}
+		} else {
+			wait = max(wait, limit.rl.Reserve())
+		}
+		taken = append(taken, limit.rl)
+	}
+
+	return wait, nil
+}
@@ -0,0 +1,101 @@
+/*
+ *   Copyright (c) 2023 CodapeWild
+ *   All rights reserved.
+
+ *   Licensed under the Apache License, Version 2.0 (the "License");
+ *   you may not use this file except in compliance with the License.
+ *   You may obtain a copy of the License at
+
+ *   http://www.apache.org/licenses/LICENSE-2.0
+
+ *   Unless required by applicable law or agreed to in writing, software
+ *   distributed under the License is distributed on an "AS IS" BASIS,
+ *   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *   See the License for the specific language governing permissions and
+ *   limitations under the License.
+ */
+
+package threadpool
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestRateLimiter(t *testing.T) {
+	rl := NewRateLimiter(100, 2)
+	if !rl.Allow() || !rl.Allow() || rl.Allow() {
+		t.Fatal("expect burst of 2 tokens")
+	}
+	if wait := rl.Reserve(); wait <= 0 || wait > 10*time.Millisecond {
+		t.Fatalf("expect to wait about 10ms got %s", wait)
+	}
+	if err := rl.Wait(context.TODO()); err != nil {
+		t.Fatal(err.Error())
+	}
+}
+
+func TestWorkerPoolRateLimit(t *testing.T) {
+	wp := NewWorkerPool(4,
+		WorkerPoolWithRateLimit(NewRateLimiter(100, 1), RateLimitDelay),
+		WorkerPoolWithClassRateLimit("upstream", NewRateLimiter(1, 1), RateLimitReject))
+	wp.Start()
+	defer wp.Close()
+
+	var wg sync.WaitGroup
+	job := func() Job {
+		wg.Add(1)
+
+		return NewJobWrapperFromFunc(func(ctx context.Context, out chan interface{}) error { return nil },
+			func(out interface{}, err error) { wg.Done() })
+	}
+
+	// 5 jobs at 100/s are delayed for 40ms in total
+	start := time.Now()
+	for i := 0; i < 5; i++ {
+		if err := wp.SendJob(context.TODO(), job()); err != nil {
+			t.Fatal(err.Error())
+		}
+	}
+	wg.Wait()
+	if d := time.Since(start); d < 35*time.Millisecond {
+		t.Fatalf("expect jobs delayed got %s", d)
+	}
+
+	if err := wp.SendJob(context.TODO(), job(), JobWithClass("upstream")); err != nil {
+		t.Fatal(err.Error())
+	}
+	if err := wp.SendJob(context.TODO(), NewJobWrapperFromFunc(func(ctx context.Context, out chan interface{}) error { return nil }, nil), JobWithClass("upstream")); !errors.Is(err, ErrRateLimited) {
+		t.Fatalf("expect ErrRateLimited got %v", err)
+	}
+	wg.Wait()
+}
+
+func TestWorkerPoolRateLimitGiveBack(t *testing.T) {
+	var (
+		global = NewRateLimiter(0.001, 1)
+		class  = NewRateLimiter(0.001, 1)
+	)
+	wp := NewWorkerPool(1,
+		WorkerPoolWithRateLimit(global, RateLimitReject),
+		WorkerPoolWithClassRateLimit("upstream", class, RateLimitReject))
+	wp.Start()
+	defer wp.Close()
+
+	noop := func() Job {
+		return NewJobWrapperFromFunc(func(ctx context.Context, out chan interface{}) error { return nil }, nil)
+	}
+	if err := wp.SendJob(context.TODO(), noop()); err != nil {
+		t.Fatal(err.Error())
+	}
+	// admitted by class limit but rejected by global limit
+	if err := wp.SendJob(context.TODO(), noop(), JobWithClass("upstream")); !errors.Is(err, ErrRateLimited) {
+		t.Fatalf("expect ErrRateLimited got %v", err)
+	}
+	if !class.Allow() {
+		t.Fatal("expect class token given back")
+	}
+}
@@ -0,0 +1,109 @@
+/*
+ *   Copyright (c) 2023 CodapeWild
+ *   All rights reserved.
+
+ *   Licensed under the Apache License, Version 2.0 (the "License");
+ *   you may not use this file except in compliance with the License.
+ *   You may obtain a copy of the License at
+
+ *   http://www.apache.org/licenses/LICENSE-2.0
+
+ *   Unless required by applicable law or agreed to in writing, software
+ *   distributed under the License is distributed on an "AS IS" BASIS,
+ *   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *   See the License for the specific language governing permissions and
+ *   limitations under the License.
+ */
+
+package threadpool
+
+import "time"
+
+// WorkerPoolWithScaling lets the pool scale between min and max workers, a worker is added
+// whenever jobs are backed up in queue and workers idle for idle duration exit until min left.
+func WorkerPoolWithScaling(min, max int, idle time.Duration) WorkerPoolOption {
+	return func(wp *WorkerPool) {
+		if min <= 0 {
+			min = 1
+		}
+		if max < min {
+			max = min
+		}
+		wp.minWorkers = min
+		wp.maxWorkers = max
+		wp.idleTimeout = idle
+	}
+}
+
+// Resize sets the number of workers running, the bounds of scaling are widened if n is out of them.
+// Workers beyond n exit after the jobs in hand done.
+func (wp *WorkerPool) Resize(n int) {
+	if n <= 0 {
+		n = 1
+	}
+
+	wp.mu.Lock()
+	defer wp.mu.Unlock()
+
+	wp.minWorkers = min(wp.minWorkers, n)
+	wp.maxWorkers = max(wp.maxWorkers, n)
+	if n > wp.maxThreads {
+		wp.spawn(n - wp.maxThreads)
+	} else if n < wp.maxThreads {
+		if wp.started {
+			wp.retiring += wp.maxThreads - n
+			close(wp.resized)
+			wp.resized = make(chan struct{})
+		}
+		wp.maxThreads = n
+	}
+}
+
+// Workers returns the number of workers running.
+func (wp *WorkerPool) Workers() int {
+	wp.mu.Lock()
+	defer wp.mu.Unlock()
+
+	return wp.maxThreads
+}
+
+// grow adds a worker if the pool is started and able to scale up.
+func (wp *WorkerPool) grow() {
+	wp.mu.Lock()
+	defer wp.mu.Unlock()
+
+	if wp.started && wp.maxThreads < wp.maxWorkers {
+		wp.spawn(1)
+	}
+}
+
+// spawn adds n workers, the workers retiring are kept instead of starting new ones.
+func (wp *WorkerPool) spawn(n int) {
+	wp.maxThreads += n
+	kept := min(n, wp.retiring)
+	wp.retiring -= kept
+	if wp.started {
+		for i := kept; i < n; i++ {
+			go wp.workLoop()
+		}
+	}
+}
+
+// retire reports whether the worker should exit, a worker idle exits if more than minWorkers running.
+func (wp *WorkerPool) retire(idle bool) bool {
+	wp.mu.Lock()
+	defer wp.mu.Unlock()
+
+	if wp.retiring > 0 {
+		wp.retiring--
+
+		return true
+	}
+	if idle && wp.maxThreads > wp.minWorkers {
+		wp.maxThreads--
+
+		return true
+	}
+
+	return false
+}
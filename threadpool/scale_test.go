@@ -0,0 +1,71 @@
+/*
+ *   Copyright (c) 2023 CodapeWild
+ *   All rights reserved.
+
+ *   Licensed under the Apache License, Version 2.0 (the "License");
+ *   you may not use this file except in compliance with the License.
+ *   You may obtain a copy of the License at
+
+ *   http://www.apache.org/licenses/LICENSE-2.0
+
+ *   Unless required by applicable law or agreed to in writing, software
+ *   distributed under the License is distributed on an "AS IS" BASIS,
+ *   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *   See the License for the specific language governing permissions and
+ *   limitations under the License.
+ */
+
+package threadpool
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+)
+
+func waitWorkers(t *testing.T, wp *WorkerPool, n int) {
+	t.Helper()
+
+	for deadline := time.Now().Add(time.Second); wp.Workers() != n; time.Sleep(5 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatalf("expect %d workers got %d", n, wp.Workers())
+		}
+	}
+}
+
+func TestWorkerPoolScaling(t *testing.T) {
+	wp := NewWorkerPool(1, WorkerPoolWithScaling(1, 4, 20*time.Millisecond))
+	wp.Start()
+	defer wp.Close()
+
+	// jobs backed up scale the pool up to max
+	var (
+		release = make(chan struct{})
+		wg      sync.WaitGroup
+	)
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go wp.SendJob(context.TODO(), NewJobWrapperFromFunc(func(ctx context.Context, out chan interface{}) error {
+			defer wg.Done()
+			<-release
+
+			return nil
+		}, nil))
+	}
+	waitWorkers(t, wp, 4)
+	close(release)
+	wg.Wait()
+
+	// idle workers are reaped down to min
+	waitWorkers(t, wp, 1)
+
+	wp.Resize(6)
+	if n := wp.Workers(); n != 6 {
+		t.Fatalf("expect 6 workers got %d", n)
+	}
+	wp.Resize(2)
+	if n := wp.Workers(); n != 2 {
+		t.Fatalf("expect 2 workers got %d", n)
+	}
+}
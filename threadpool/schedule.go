@@ -0,0 +1,244 @@
+/*
+ *   Copyright (c) 2023 CodapeWild
+ *   All rights reserved.
+
+ *   Licensed under the Apache License, Version 2.0 (the "License");
+ *   you may not use this file except in compliance with the License.
+ *   You may obtain a copy of the License at
+
+ *   http://www.apache.org/licenses/LICENSE-2.0
+
+ *   Unless required by applicable law or agreed to in writing, software
+ *   distributed under the License is distributed on an "AS IS" BASIS,
+ *   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *   See the License for the specific language governing permissions and
+ *   limitations under the License.
+ */
+
+package threadpool
+
+import (
+	"container/heap"
+	"errors"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var ErrInvalidSchedule = errors.New("invalid schedule")
+
+// Schedule returns the next time after t a recurring job should run, zero time means never.
+type Schedule interface {
+	Next(t time.Time) time.Time
+}
+
+type every time.Duration
+
+func (e every) Next(t time.Time) time.Time {
+	if e <= 0 {
+		return time.Time{}
+	}
+
+	return t.Add(time.Duration(e))
+}
+
+// Every fires every d duration.
+func Every(d time.Duration) Schedule {
+	return every(d)
+}
+
+// cronSchedule keeps the values allowed of each field as bits.
+type cronSchedule struct {
+	minute, hour, dom, month, dow uint64
+	anyDom, anyDow                bool
+}
+
+type cronField struct {
+	min, max int
+}
+
+var _cronFields = [5]cronField{{0, 59}, {0, 23}, {1, 31}, {1, 12}, {0, 6}}
+
+// Cron parses standard 5-field crontab spec "minute hour day-of-month month day-of-week",
+// each field supports *, numbers, ranges a-b, lists a,b and steps */n or a-b/n.
+// Like crontab a day matches if either day-of-month or day-of-week matches when both restricted.
+func Cron(spec string) (Schedule, error) {
+	fields := strings.Fields(spec)
+	if len(fields) != len(_cronFields) {
+		return nil, ErrInvalidSchedule
+	}
+
+	var (
+		bits [5]uint64
+		err  error
+	)
+	for i, field := range fields {
+		if bits[i], err = parseCronField(field, _cronFields[i]); err != nil {
+			return nil, err
+		}
+	}
+
+	return &cronSchedule{
+		minute: bits[0],
+		hour:   bits[1],
+		dom:    bits[2],
+		month:  bits[3],
+		dow:    bits[4],
+		anyDom: fields[2] == "*",
+		anyDow: fields[4] == "*",
+	}, nil
+}
+
+func parseCronField(field string, bounds cronField) (uint64, error) {
+	var bits uint64
+	for _, part := range strings.Split(field, ",") {
+		rng, step, hasStep := strings.Cut(part, "/")
+		n := 1
+		if hasStep {
+			var err error
+			if n, err = strconv.Atoi(step); err != nil || n <= 0 {
+				return 0, ErrInvalidSchedule
+			}
+		}
+
+		min, max := bounds.min, bounds.max
+		if rng != "*" {
+			lo, hi, isRange := strings.Cut(rng, "-")
+			var err error
+			if min, err = strconv.Atoi(lo); err != nil {
+				return 0, ErrInvalidSchedule
+			}
+			if isRange {
+				if max, err = strconv.Atoi(hi); err != nil {
+					return 0, ErrInvalidSchedule
+				}
+			} else if !hasStep {
+				max = min
+			}
+		}
+		if min < bounds.min || max > bounds.max || min > max {
+			return 0, ErrInvalidSchedule
+		}
+
+		for v := min; v <= max; v += n {
+			bits |= 1 << uint(v)
+		}
+	}
+
+	return bits, nil
+}
+
+func (cs *cronSchedule) Next(t time.Time) time.Time {
+	// steps are taken by local clock fields, Truncate works on absolute time and breaks
+	// in zones with offset of non-whole hour
+	t = t.Add(time.Minute - time.Duration(t.Second())*time.Second - time.Duration(t.Nanosecond()))
+	// no match within 5 years means the spec never fires, e.g. 30th of February
+	for limit := t.AddDate(5, 0, 0); t.Before(limit); {
+		switch {
+		case cs.month&(1<<uint(t.Month())) == 0:
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, t.Location())
+		case !cs.matchDay(t):
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, t.Location())
+		case cs.hour&(1<<uint(t.Hour())) == 0:
+			t = t.Add(time.Duration(60-t.Minute()) * time.Minute)
+		case cs.minute&(1<<uint(t.Minute())) == 0:
+			t = t.Add(time.Minute)
+		default:
+			return t
+		}
+	}
+
+	return time.Time{}
+}
+
+func (cs *cronSchedule) matchDay(t time.Time) bool {
+	dom := cs.dom&(1<<uint(t.Day())) != 0
+	dow := cs.dow&(1<<uint(t.Weekday())) != 0
+	if cs.anyDom || cs.anyDow {
+		return dom && dow
+	}
+
+	return dom || dow
+}
+
+// timedJob is a job waiting in scheduler until at, it is scheduled again by sched if not nil.
+type timedJob struct {
+	at    time.Time
+	job   *JobWrapper
+	sched Schedule
+}
+
+type timedJobs []*timedJob
+
+func (tjs timedJobs) Len() int           { return len(tjs) }
+func (tjs timedJobs) Less(i, j int) bool { return tjs[i].at.Before(tjs[j].at) }
+func (tjs timedJobs) Swap(i, j int)      { tjs[i], tjs[j] = tjs[j], tjs[i] }
+func (tjs *timedJobs) Push(x any)        { *tjs = append(*tjs, x.(*timedJob)) }
+func (tjs *timedJobs) Pop() any {
+	old := *tjs
+	tj := old[len(old)-1]
+	*tjs = old[:len(old)-1]
+
+	return tj
+}
+
+// scheduler holds timed jobs in a min-heap by time and hands them over once due.
+type scheduler struct {
+	sync.Mutex
+	jobs timedJobs
+	wake chan struct{}
+}
+
+func (sch *scheduler) add(tj *timedJob) {
+	sch.Lock()
+	heap.Push(&sch.jobs, tj)
+	sch.Unlock()
+
+	select {
+	case sch.wake <- struct{}{}:
+	default:
+	}
+}
+
+// run calls dispatch in new goroutine for every job due until closer closed.
+func (sch *scheduler) run(closer chan struct{}, dispatch func(tj *timedJob)) {
+	timer := time.NewTimer(time.Hour)
+	timer.Stop()
+	defer timer.Stop()
+
+	for {
+		sch.Lock()
+		now := time.Now()
+		for len(sch.jobs) != 0 && !sch.jobs[0].at.After(now) {
+			go dispatch(heap.Pop(&sch.jobs).(*timedJob))
+		}
+		wait := time.Hour
+		if len(sch.jobs) != 0 {
+			wait = sch.jobs[0].at.Sub(now)
+		}
+		sch.Unlock()
+
+		timer.Reset(wait)
+		select {
+		case <-closer:
+			return
+		case <-sch.wake:
+			if !timer.Stop() {
+				<-timer.C
+			}
+		case <-timer.C:
+		}
+	}
+}
+
+func (sch *scheduler) size() int {
+	sch.Lock()
+	defer sch.Unlock()
+
+	return len(sch.jobs)
+}
+
+func newScheduler() *scheduler {
+	return &scheduler{wake: make(chan struct{}, 1)}
+}
@@ -0,0 +1,116 @@
+/*
+ *   Copyright (c) 2023 CodapeWild
+ *   All rights reserved.
+
+ *   Licensed under the Apache License, Version 2.0 (the "License");
+ *   you may not use this file except in compliance with the License.
+ *   You may obtain a copy of the License at
+
+ *   http://www.apache.org/licenses/LICENSE-2.0
+
+ *   Unless required by applicable law or agreed to in writing, software
+ *   distributed under the License is distributed on an "AS IS" BASIS,
+ *   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *   See the License for the specific language governing permissions and
+ *   limitations under the License.
+ */
+
+package threadpool
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestCronNext(t *testing.T) {
+	base := time.Date(2024, time.January, 31, 10, 17, 30, 0, time.UTC)
+	tests := []struct {
+		spec string
+		next time.Time
+	}{
+		{"* * * * *", time.Date(2024, time.January, 31, 10, 18, 0, 0, time.UTC)},
+		{"*/15 * * * *", time.Date(2024, time.January, 31, 10, 30, 0, 0, time.UTC)},
+		{"0 9-17/4 * * *", time.Date(2024, time.January, 31, 13, 0, 0, 0, time.UTC)},
+		{"30 8 1 * *", time.Date(2024, time.February, 1, 8, 30, 0, 0, time.UTC)},
+		{"0 0 29 2 *", time.Date(2024, time.February, 29, 0, 0, 0, 0, time.UTC)},
+		{"0 12 * * 0,6", time.Date(2024, time.February, 3, 12, 0, 0, 0, time.UTC)},
+		{"0 0 30 2 *", time.Time{}},
+	}
+	for _, tt := range tests {
+		sched, err := Cron(tt.spec)
+		if err != nil {
+			t.Fatalf("%s: %s", tt.spec, err.Error())
+		}
+		if next := sched.Next(base); !next.Equal(tt.next) {
+			t.Fatalf("%s: expect %s got %s", tt.spec, tt.next, next)
+		}
+	}
+
+	// offset of half an hour like Asia/Kolkata
+	ist := time.FixedZone("IST", 5*3600+1800)
+	base = time.Date(2024, time.January, 31, 10, 17, 30, 0, ist)
+	for spec, next := range map[string]time.Time{
+		"0 3 * * *":    time.Date(2024, time.February, 1, 3, 0, 0, 0, ist),
+		"45 11 * * *":  time.Date(2024, time.January, 31, 11, 45, 0, 0, ist),
+		"*/20 * * * *": time.Date(2024, time.January, 31, 10, 20, 0, 0, ist),
+	} {
+		sched, err := Cron(spec)
+		if err != nil {
+			t.Fatalf("%s: %s", spec, err.Error())
+		}
+		if got := sched.Next(base); !got.Equal(next) {
+			t.Fatalf("%s: expect %s got %s", spec, next, got)
+		}
+	}
+
+	for _, spec := range []string{"* * * *", "60 * * * *", "*/0 * * * *", "5-1 * * * *"} {
+		if _, err := Cron(spec); err == nil {
+			t.Fatalf("%s: expect invalid schedule", spec)
+		}
+	}
+}
+
+func TestWorkerPoolPriorityAndDelay(t *testing.T) {
+	wp := NewWorkerPool(1, WorkerPoolWithQueue(10, QueueBlock))
+	order := make(chan string, 10)
+	job := func(name string) Job {
+		return NewJobWrapperFromFunc(func(ctx context.Context, out chan interface{}) error {
+			order <- name
+
+			return nil
+		}, nil)
+	}
+
+	// jobs queued before workers start are taken by priority
+	wp.SendJob(context.TODO(), job("low"), JobWithPriority(PriorityLow))
+	wp.SendJob(context.TODO(), job("normal"))
+	wp.SendJob(context.TODO(), job("high"), JobWithPriority(PriorityHigh))
+	wp.SendJobAfter(context.TODO(), 30*time.Millisecond, job("delayed"), JobWithPriority(PriorityHigh))
+	wp.Start()
+	defer wp.Close()
+
+	for _, expect := range []string{"high", "normal", "low", "delayed"} {
+		select {
+		case name := <-order:
+			if name != expect {
+				t.Fatalf("expect %s got %s", expect, name)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("%s not run", expect)
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.TODO())
+	if err := wp.SendRecurringJob(ctx, Every(10*time.Millisecond), job("recurring")); err != nil {
+		t.Fatal(err.Error())
+	}
+	for i := 0; i < 3; i++ {
+		select {
+		case <-order:
+		case <-time.After(time.Second):
+			t.Fatal("recurring job not run")
+		}
+	}
+	cancel()
+}
@@ -0,0 +1,129 @@
+/*
+ *   Copyright (c) 2023 CodapeWild
+ *   All rights reserved.
+
+ *   Licensed under the Apache License, Version 2.0 (the "License");
+ *   you may not use this file except in compliance with the License.
+ *   You may obtain a copy of the License at
+
+ *   http://www.apache.org/licenses/LICENSE-2.0
+
+ *   Unless required by applicable law or agreed to in writing, software
+ *   distributed under the License is distributed on an "AS IS" BASIS,
+ *   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *   See the License for the specific language governing permissions and
+ *   limitations under the License.
+ */
+
+package threadpool
+
+import (
+	"errors"
+	"sync/atomic"
+	"time"
+)
+
+// Stats is a snapshot of WorkerPool.
+type Stats struct {
+	Workers   int           // number of workers running
+	Active    int           // number of jobs executing
+	Queued    int           // number of jobs waiting in queues
+	Scheduled int           // number of jobs waiting for their time
+	Completed int64         // number of jobs finished including the failed ones
+	Failed    int64         // number of jobs finished with error
+	TimedOut  int64         // number of jobs failed with ErrTaskTimeout
+	Panicked  int64         // number of jobs panicked
+	Rejected  int64         // number of jobs rejected when sending
+	AvgWait   time.Duration // average duration jobs waited in queue
+	AvgRun    time.Duration // average duration jobs processed
+}
+
+// PoolHooks are called along the lifecycle of jobs, the job passed in is the one sent into pool.
+// OnStart, OnFinish and OnPanic are called in the worker goroutine running the job, OnReject is
+// called in the goroutine dispatching the job, which is the caller of SendJob unless the job is
+// delayed or keyed. Hooks should return fast since they hold up workers and callers.
+type PoolHooks struct {
+	OnStart  func(job Job)
+	OnFinish func(job Job, latency time.Duration, err error)
+	OnPanic  func(job Job, recovered any)
+	OnReject func(job Job, err error) // blocks SendJob caller until returned
+}
+
+// WorkerPoolWithHooks installs hooks, nil hook is skipped.
+func WorkerPoolWithHooks(hooks PoolHooks) WorkerPoolOption {
+	return func(wp *WorkerPool) {
+		wp.hooks = hooks
+	}
+}
+
+type poolStats struct {
+	active    atomic.Int64
+	completed atomic.Int64
+	failed    atomic.Int64
+	timedOut  atomic.Int64
+	panicked  atomic.Int64
+	rejected  atomic.Int64
+	waitNanos atomic.Int64
+	runNanos  atomic.Int64
+}
+
+// Stats returns the snapshot of pool.
+func (wp *WorkerPool) Stats() Stats {
+	stats := Stats{
+		Workers:   wp.Workers(),
+		Active:    int(wp.stats.active.Load()),
+		Scheduled: wp.timer.size(),
+		Completed: wp.stats.completed.Load(),
+		Failed:    wp.stats.failed.Load(),
+		TimedOut:  wp.stats.timedOut.Load(),
+		Panicked:  wp.stats.panicked.Load(),
+		Rejected:  wp.stats.rejected.Load(),
+	}
+	for _, queue := range wp.queues {
+		stats.Queued += len(queue)
+	}
+	if stats.Completed != 0 {
+		stats.AvgWait = time.Duration(wp.stats.waitNanos.Load() / stats.Completed)
+		stats.AvgRun = time.Duration(wp.stats.runNanos.Load() / stats.Completed)
+	}
+
+	return stats
+}
+
+func (wp *WorkerPool) jobStarted(jw *JobWrapper) {
+	wp.stats.active.Add(1)
+	if !jw.queuedAt.IsZero() {
+		wp.stats.waitNanos.Add(int64(time.Since(jw.queuedAt)))
+	}
+	if wp.hooks.OnStart != nil {
+		wp.hooks.OnStart(jw.origin)
+	}
+}
+
+func (wp *WorkerPool) jobFinished(jw *JobWrapper, latency time.Duration, err error, recovered any) {
+	wp.stats.active.Add(-1)
+	wp.stats.completed.Add(1)
+	wp.stats.runNanos.Add(int64(latency))
+	if err != nil {
+		wp.stats.failed.Add(1)
+		if errors.Is(err, ErrTaskTimeout) {
+			wp.stats.timedOut.Add(1)
+		}
+	}
+	if recovered != nil {
+		wp.stats.panicked.Add(1)
+		if wp.hooks.OnPanic != nil {
+			wp.hooks.OnPanic(jw.origin, recovered)
+		}
+	}
+	if wp.hooks.OnFinish != nil {
+		wp.hooks.OnFinish(jw.origin, latency, err)
+	}
+}
+
+func (wp *WorkerPool) jobRejected(jw *JobWrapper, err error) {
+	wp.stats.rejected.Add(1)
+	if wp.hooks.OnReject != nil {
+		wp.hooks.OnReject(jw.origin, err)
+	}
+}